@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var appendOutput, appendPrefix string
+var appendStore bool
+
+var appendCmd = &cobra.Command{
+	Use:   "append FILE|DIR...",
+	Short: "Add files to an S3 zip archive without downloading it",
+	Long: `Adds local files, and directories with everything in them, to a zip
+	archive in S3. The new archive is written with a multipart upload in
+	which the existing entries are copied within S3, so only the new files
+	and the rebuilt central directory are uploaded (and, when the archive is
+	smaller than S3's 5 MiB minimum part size, downloaded first). Entries are
+	named by the paths given, below --prefix if set. Without -o, the archive
+	is replaced atomically, provided it hasn't changed since it was read;
+	with -o, the new archive is written there and the original is left as
+	it is. Either way, the new archive keeps the original's headers, user
+	metadata, storage class, encryption and tags.
+
+	ex:
+	zipspy append -b myBucket -k release.zip CHANGELOG.md
+	zipspy append -b myBucket -k release.zip --prefix docs/ manual/
+	zipspy append -b myBucket -k release.zip -o s3://myBucket/release-2.zip build/`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		_, err := updateArchive(cmd, appendOutput, func(a *zipfile.Archive, u *zipfile.Update) error {
+			existing := make(map[string]bool)
+			for _, e := range a.Files() {
+				existing[e.Name] = true
+			}
+			for _, arg := range args {
+				if err := appendPath(u, arg, existing); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return err
+	},
+}
+
+// updateArchive opens the archive given with -b and -k, has change make an
+// Update of it, and commits that to output (s3://bucket/key) or, if output
+// is empty, in place of the archive.
+func updateArchive(cmd *cobra.Command, output string, change func(a *zipfile.Archive, u *zipfile.Update) error) (*zipfile.UpdateResult, error) {
+	dstBucket, dstKey := bucket, key
+	if output != "" {
+		var err error
+		if dstBucket, dstKey, err = parseS3URI(output); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := commandContext(cmd)
+	defer cancel()
+	a, err := zipfile.Open(ctx, zipfile.S3(bucket, key))
+	if err != nil {
+		log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+		return nil, err
+	}
+	u, err := a.Update(dstBucket, dstKey)
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+	if err := change(a, u); err != nil {
+		return nil, err
+	}
+	res, err := u.Commit(ctx)
+	if showStats {
+		writeStats(os.Stderr, a.Stats())
+	}
+	if err != nil {
+		log.Errorf("error writing archive (bucket: %s)(key: %s), err: %v", dstBucket, dstKey, err)
+		return nil, err
+	}
+	printUpdateResult(dstBucket, dstKey, res)
+	return res, nil
+}
+
+// appendPath adds the file or directory tree at root to the archive,
+// skipping the directories it already has.
+func appendPath(u *zipfile.Update, root string, existing map[string]bool) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := entryName(p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			if name == "" || existing[name+"/"] {
+				return nil
+			}
+			name += "/"
+		case !fi.Mode().IsRegular():
+			log.Warnf("skipping file that is not a regular file or directory (name: %s)", p)
+			return nil
+		}
+		fh, err := reader.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		fh.Name = name
+		if appendStore {
+			fh.Method = reader.Store
+		}
+		w, err := u.Create(fh)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("adding: %s\n", name)
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(w, f); err != nil {
+			log.Errorf("error adding file (name: %s), err: %v", p, err)
+			return err
+		}
+		return nil
+	})
+}
+
+// entryName returns the name in the archive of the local file at p, as zip
+// would store it: with slashes, relative, and below --prefix.
+func entryName(p string) (string, error) {
+	name := strings.TrimLeft(filepath.ToSlash(filepath.Clean(p)), "/")
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("can't name %s in the archive, as it is outside the current directory (use --prefix and a relative path)", p)
+	}
+	if name == "." {
+		name = ""
+	}
+	if appendPrefix != "" {
+		name = strings.TrimPrefix(path.Join(appendPrefix, name), "/")
+	}
+	return name, nil
+}
+
+// printUpdateResult reports where an updated archive was written and how
+// much of it was copied within S3 rather than uploaded.
+func printUpdateResult(bucket, key string, res *zipfile.UpdateResult) {
+	fmt.Printf("Wrote s3://%s/%s (%d bytes in %d parts): %d bytes copied within S3, %d uploaded", bucket, key, res.Size, res.Parts, res.Copied, res.Uploaded)
+	if res.Downloaded > 0 {
+		fmt.Printf(" (%d of them downloaded from the old archive)", res.Downloaded)
+	}
+	fmt.Println()
+	if res.VersionID != "" {
+		fmt.Printf("Version ID: %s\n", res.VersionID)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(appendCmd)
+	appendCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object)")
+	appendCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	appendCmd.Flags().StringVarP(&appendOutput, "output", "o", "", "write the new archive to s3://bucket/key instead of replacing the original")
+	appendCmd.Flags().StringVar(&appendPrefix, "prefix", "", "directory in the archive to add the files below, e.g. docs/")
+	appendCmd.Flags().BoolVarP(&appendStore, "store", "0", false, "store the files without compressing them")
+}
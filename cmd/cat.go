@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alec-rabold/zipspy/pkg/flateindex"
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var indexFile string
+var useSidecar bool
+
+var catCmd = &cobra.Command{
+	Use:   "cat [ENTRY]",
+	Short: "Write a single file from an S3 zip archive to stdout",
+	Long: `Writes the contents of the archive entry with the exact name ENTRY to
+	stdout. ENTRY may be omitted for objects holding a single file, such as
+	seekable zstd files, which are read by frame. Entries of nested archives
+	are addressed as outer.zip!/inner.txt. With --range, only the requested
+	bytes are written; for stored (uncompressed) entries only those bytes are
+	downloaded. Compressed entries are decompressed from the start unless a
+	seek index built with the index command is given with --index or
+	--sidecar.
+
+	ex:
+	zipspy cat -b myBucket -k myKey path/to/plan.txt
+	zipspy cat -b myBucket -k myKey data/table.parquet --range -8
+	zipspy cat -b myBucket -k myKey db.sqlite --range 0-99
+	zipspy cat -b myBucket -k myKey logs/server.log --sidecar --range -4096
+	zipspy cat -b myBucket -k bundle.zip 'service-a.zip!/config/app.yaml'
+	zipspy cat -b myBucket -k logs/app.log.zst --range 1048576-2097151`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 || bucket == "" || key == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		r := zipfile.ByteRange{End: -1}
+		if byteRange != "" {
+			var err error
+			if r, err = zipfile.ParseByteRange(byteRange); err != nil {
+				return err
+			}
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		z, err := openExtractor(ctx)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		defer printStats(z)
+		var file *reader.File
+		if len(args) == 1 {
+			z, file, err = findEntry(z, args[0])
+		} else {
+			file, err = onlyEntry(z)
+		}
+		if err != nil {
+			return err
+		}
+		var idx *flateindex.Index
+		if file.Method == reader.Deflate && indexFile != "" {
+			if idx, err = loadIndex(indexFile); err != nil {
+				log.Errorf("error reading index (name: %s), err: %v", indexFile, err)
+				return err
+			}
+		} else if file.Method == reader.Deflate && useSidecar {
+			if idx, err = z.LoadIndex(file); err != nil {
+				log.Errorf("error reading index (name: %s), err: %v", z.IndexKey(file), err)
+				return err
+			}
+		}
+		var rc io.ReadCloser
+		if idx != nil {
+			rc, err = z.OpenRangeIndexed(file, r, idx)
+		} else {
+			rc, err = z.OpenRange(file, r)
+		}
+		if err != nil {
+			log.Errorf("error opening file (name: %s), err: %v", file.Name, err)
+			return err
+		}
+		defer rc.Close()
+		if _, err := io.Copy(os.Stdout, rc); err != nil {
+			log.Errorf("error reading file (name: %s), err: %v", file.Name, err)
+			return err
+		}
+		return nil
+	},
+}
+
+// findEntry returns the archive entry with the given name, which may be a
+// path through nested archives such as service-a.zip!/config/app.yaml, along
+// with the extractor for the (innermost) archive that holds it.
+func findEntry(z *zipfile.FileExtractor, name string) (*zipfile.FileExtractor, *reader.File, error) {
+	z, name, err := z.Resolve(name)
+	if err != nil {
+		log.Errorf("error opening nested archive, err: %v", err)
+		return nil, nil, err
+	}
+	file, err := z.Entry(name)
+	if err != nil {
+		log.Errorf("error finding file (name: %s), err: %v", name, err)
+		return nil, nil, err
+	}
+	return z, file, nil
+}
+
+// onlyEntry returns the entry of an archive that holds a single file.
+func onlyEntry(z *zipfile.FileExtractor) (*reader.File, error) {
+	zFiles, err := z.Files()
+	if err != nil {
+		log.Errorf("error reading archive, err: %v", err)
+		return nil, err
+	}
+	if len(zFiles) != 1 {
+		return nil, fmt.Errorf("archive holds %d files, name the one to write", len(zFiles))
+	}
+	return zFiles[0], nil
+}
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+	catCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object)")
+	catCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	catCmd.Flags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	catCmd.Flags().StringVar(&byteRange, "range", "", "only write bytes START-END (inclusive); START- and -SUFFIX are also accepted")
+	catCmd.Flags().StringVar(&indexFile, "index", "", "local seek index written by the index command")
+	catCmd.Flags().BoolVar(&useSidecar, "sidecar", false, "use the seek index stored next to the archive by the index command")
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/textdiff"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var diffFiles []string
+var diffContent bool
+var diffContext int
+var diffMaxSize string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff s3://bucket/key[?versionId=ID] s3://bucket/key[?versionId=ID]",
+	Short: "Compare two S3 archives",
+	Long: `Reads only the directories of the two archives and prints the entries
+	added (A), deleted (D) and modified (M) in the second, comparing the
+	sizes and CRC-32s of entries with the same name. With --content, the
+	modified entries are also fetched and a unified diff of those holding
+	text is printed. The archives may be in different buckets, nested, or
+	versions of the same object. Exits with status 1 if they differ, and 2
+	if there was an error.
+
+	ex:
+	zipspy diff s3://myBucket/release-1.2.zip s3://myBucket/release-1.3.zip
+	zipspy diff s3://old/app.zip s3://new/app.zip -f config/ --content
+	zipspy diff 's3://myBucket/app.zip?versionId=3HL4kqtJlcpXroDTDmJ.rmSpXd3dIbrHY' s3://myBucket/app.zip
+	zipspy diff 's3://myBucket/bundle.zip!/service-a.zip' 's3://myBucket/bundle-2.zip!/service-a.zip'`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{resultStatus: "1"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxSize, err := parseBytes("max-size", diffMaxSize)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		var archives [2]*zipfile.Archive
+		for i, arg := range args {
+			bucket, key, version, err := parseVersionedURI(arg)
+			if err != nil {
+				return err
+			}
+			if archives[i], err = zipfile.Open(ctx, zipfile.S3Version(bucket, key, version)); err != nil {
+				log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+				return err
+			}
+		}
+		a, b := archives[0], archives[1]
+		differ, err := diffArchives(ctx, a, b, maxSize)
+		if showStats {
+			var total zipfile.Stats
+			addStats(&total, a.Stats())
+			addStats(&total, b.Stats())
+			writeStats(os.Stderr, total)
+		}
+		if err != nil {
+			return err
+		}
+		if differ {
+			return resultFailed(cmd)
+		}
+		return nil
+	},
+}
+
+// diffArchives prints the differences between a and b, reporting whether
+// there were any.
+func diffArchives(ctx context.Context, a, b *zipfile.Archive, maxSize int64) (bool, error) {
+	var sel zipfile.Selector
+	if len(diffFiles) > 0 {
+		sel = zipfile.Match(diffFiles...)
+	}
+	changes := compareArchives(a, b, sel)
+	for _, c := range changes {
+		switch c.status {
+		case 'A':
+			fmt.Printf("A\t%s\t%d bytes\n", c.name, c.b.UncompressedSize64)
+		case 'D':
+			fmt.Printf("D\t%s\t%d bytes\n", c.name, c.a.UncompressedSize64)
+		case 'M':
+			fmt.Printf("M\t%s\t%d -> %d bytes, CRC-32 %08x -> %08x\n", c.name,
+				c.a.UncompressedSize64, c.b.UncompressedSize64, c.a.CRC32, c.b.CRC32)
+		}
+	}
+	if !diffContent {
+		return len(changes) > 0, nil
+	}
+	for _, c := range changes {
+		if c.status != 'M' {
+			continue
+		}
+		if err := printContentDiff(ctx, a, b, c, maxSize); err != nil {
+			log.Errorf("error comparing file (name: %s), err: %v", c.name, err)
+			return true, err
+		}
+	}
+	return len(changes) > 0, nil
+}
+
+// entryChange is an entry added (A), deleted (D) or modified (M) between
+// two archives. a or b is nil for added and deleted entries.
+type entryChange struct {
+	status byte
+	name   string
+	a, b   *zipfile.Entry
+}
+
+// compareArchives returns the entries chosen by sel that differ between a
+// and b in size or CRC-32, sorted by name. Of several entries with the same
+// name, the first is compared.
+func compareArchives(a, b *zipfile.Archive, sel zipfile.Selector) []entryChange {
+	index := func(ar *zipfile.Archive) map[string]*zipfile.Entry {
+		m := make(map[string]*zipfile.Entry)
+		for _, e := range ar.Files() {
+			if _, dup := m[e.Name]; !dup && (sel == nil || sel(e)) {
+				m[e.Name] = e
+			}
+		}
+		return m
+	}
+	ea, eb := index(a), index(b)
+	var changes []entryChange
+	for name, x := range ea {
+		y, ok := eb[name]
+		switch {
+		case !ok:
+			changes = append(changes, entryChange{'D', name, x, nil})
+		case x.CRC32 != y.CRC32 || x.UncompressedSize64 != y.UncompressedSize64:
+			changes = append(changes, entryChange{'M', name, x, y})
+		}
+	}
+	for name, y := range eb {
+		if _, ok := ea[name]; !ok {
+			changes = append(changes, entryChange{'A', name, nil, y})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].name < changes[j].name })
+	return changes
+}
+
+// printContentDiff fetches both versions of a modified entry and prints a
+// unified diff of them, or a note if either is binary or larger than
+// maxSize (if > 0).
+func printContentDiff(ctx context.Context, a, b *zipfile.Archive, c entryChange, maxSize int64) error {
+	aName, bName := "a/"+c.name, "b/"+c.name
+	if maxSize > 0 && (int64(c.a.UncompressedSize64) > maxSize || int64(c.b.UncompressedSize64) > maxSize) {
+		fmt.Printf("Files %s and %s differ (larger than --max-size)\n", aName, bName)
+		return nil
+	}
+	var contents [2][]byte
+	errs := make(chan error, 2)
+	for i, ar := range []*zipfile.Archive{a, b} {
+		go func(i int, ar *zipfile.Archive) {
+			rc, err := ar.WithContext(ctx).Open(c.name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer rc.Close()
+			contents[i], err = ioutil.ReadAll(rc)
+			errs <- err
+		}(i, ar)
+	}
+	for range contents {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	if isBinary(contents[0]) || isBinary(contents[1]) {
+		fmt.Printf("Binary files %s and %s differ\n", aName, bName)
+		return nil
+	}
+	out, err := textdiff.Unified(aName, bName, string(contents[0]), string(contents[1]), diffContext)
+	if err == textdiff.ErrTooManyChanges {
+		fmt.Printf("Files %s and %s differ (more than %d lines changed)\n", aName, bName, textdiff.MaxChanges)
+		return nil
+	}
+	fmt.Print(out)
+	return err
+}
+
+// isBinary reports whether b looks like binary data rather than text, as
+// grep decides.
+func isBinary(b []byte) bool {
+	if len(b) > binaryCheckLen {
+		b = b[:binaryCheckLen]
+	}
+	return bytes.IndexByte(b, 0) >= 0
+}
+
+// parseVersionedURI splits s3://bucket/key?versionId=ID (the scheme and
+// version are optional) into a bucket, key and version.
+func parseVersionedURI(uri string) (bucket, key, version string, err error) {
+	const param = "?versionId="
+	if i := strings.LastIndex(uri, param); i >= 0 {
+		uri, version = uri[:i], uri[i+len(param):]
+		if version == "" {
+			return "", "", "", fmt.Errorf("invalid S3 location %q, expected s3://bucket/key?versionId=ID", uri+param)
+		}
+	}
+	bucket, key, err = parseS3URI(uri)
+	return bucket, key, version, err
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringSliceVarP(&diffFiles, "file", "f", []string{}, "only compare entries whose paths contain these strings")
+	diffCmd.Flags().BoolVar(&diffContent, "content", false, "fetch modified text entries and print a unified diff of them")
+	diffCmd.Flags().IntVarP(&diffContext, "unified", "U", 3, "lines of context around each change in --content diffs")
+	diffCmd.Flags().StringVar(&diffMaxSize, "max-size", "10M", "don't fetch entries larger than this for --content")
+}
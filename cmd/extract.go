@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"text/tabwriter"
 
 	"github.com/alec-rabold/zipspy/pkg/zipfile"
 	log "github.com/sirupsen/logrus"
@@ -10,19 +13,31 @@ import (
 )
 
 var files, outFiles []string
-var bucket, key, outFile string
+var bucket, key, outFile, byteRange string
+var parts []string
+var quiet, planOnly bool
+var maxBytes string
+
+const partsUsage = "keys of the earlier segments of a split archive, in order (default KEY.z01, KEY.z02, ... as needed)"
 
 var extractCmd = &cobra.Command{
 	Use:   "extract",
 	Short: "Extract one or more files from  S3 zip archive",
 	Long: `Downloads range(s) of bytes from S3 zip archive
 	containing the compressed file(s), the decompresses the data.
+	If extraction fails or is interrupted, output files are left as they
+	were before (files created by zipspy are removed).
 	
 	ex: 
 	zipspy extract -b myBucket -k myKey -f plan.txt
 	zipspy extract -b myBucket -k myKey -f plan.txt -o my/directory/plan.txt
 	zipspy extract -b myBucket -k myKey -f plan1.txt, plan2.txt, path/to/plan3.txt, /directory
-	zipspy extract -b myBucket -k myKey -f plan1.txt -o plan1.txt -f plan2.txt -o plan2.txt`,
+	zipspy extract -b myBucket -k myKey -f plan1.txt -o plan1.txt -f plan2.txt -o plan2.txt
+	zipspy extract -b myBucket -k myKey -f data.parquet --range -8
+	zipspy extract -b myBucket -k 'bundle.zip!/service-a.zip' -f config/
+	zipspy extract -b myBucket -k data.zip --parts data.z01,data.z02 -f plan.txt
+	zipspy extract -b myBucket -k myKey -f logs/ --plan
+	zipspy extract -b myBucket -k myKey -f logs/ --max-bytes 500M`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(files) == 0 || bucket == "" || key == "" {
 			cmd.Usage()
@@ -33,7 +48,43 @@ var extractCmd = &cobra.Command{
 			log.Error("error: must specify one output file for every search term")
 			os.Exit(1)
 		}
-		z := zipfile.NewFileExtractor(bucket, key)
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		z, err := openExtractor(ctx)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		defer printStats(z)
+		if byteRange != "" {
+			r, err := zipfile.ParseByteRange(byteRange)
+			if err != nil {
+				return err
+			}
+			z.SetRange(r)
+		}
+		if planOnly || maxBytes != "" {
+			budget, err := parseBytes("max-bytes", maxBytes)
+			if err != nil {
+				return err
+			}
+			plan, err := z.Plan(files)
+			if err != nil {
+				log.Errorf("error reading archive directory (bucket: %s)(key: %s), err: %v", bucket, key, err)
+				return err
+			}
+			if planOnly {
+				return printPlan(os.Stdout, plan, z.Stats())
+			}
+			if budget > 0 && plan.Bytes > budget {
+				err := fmt.Errorf("extraction would fetch %d bytes, more than --max-bytes %d (see --plan)", plan.Bytes, budget)
+				log.Error(err)
+				return err
+			}
+		}
+		if !quiet && stderrIsTerminal() {
+			z.SetProgress(newProgressBar(os.Stderr))
+		}
 		records, err := z.ExtractFiles(files)
 		if err != nil {
 			log.Errorf("error extracting files from archive, err: %v", err)
@@ -45,59 +96,145 @@ var extractCmd = &cobra.Command{
 					fmt.Println(f.Contents.String())
 				}
 			}
-		} else if len(outFiles) == 1 {
-			f, err := os.OpenFile(outFiles[0], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Errorf("error opening file (name: %s), err: %v", outFiles[0], err)
-				return err
+			return nil
+		}
+		outputMap := make(map[string]string) // searchTerm -> outputFile
+		for i := range files {
+			if len(outFiles) == 1 {
+				outputMap[files[i]] = outFiles[0]
+			} else {
+				outputMap[files[i]] = outFiles[i]
 			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					log.Errorf("error closing file (name: %s), err: %v", outFiles[0], err)
-					panic(err)
-				}
-			}()
-			for _, files := range records.FileMap {
-				for _, file := range files {
-					if _, err := f.Write(file.Contents.Bytes()); err != nil {
-						log.Errorf("error writing to file (name: %s), err: %v", outFiles[0], err)
-						panic(err)
-					}
+		}
+		// If writing fails or is interrupted, put every output file back
+		// the way it was rather than leave partial output behind.
+		opened := make(map[string]*appendFile)
+		abort := func() {
+			for name, f := range opened {
+				if err := f.abort(); err != nil {
+					log.Errorf("error restoring file (name: %s), err: %v", name, err)
 				}
 			}
-		} else if len(outFiles) > 1 {
-			outputMap := make(map[string]string) // searchTerm -> outputFile
-			for i := range outFiles {
-				outputMap[files[i]] = outFiles[i]
+		}
+		for searchTerm, files := range records.FileMap {
+			name := outputMap[searchTerm]
+			f, ok := opened[name]
+			if !ok {
+				if f, err = openAppend(name); err != nil {
+					log.Errorf("error opening file (name: %s), err: %v", name, err)
+					abort()
+					return err
+				}
+				opened[name] = f
 			}
-			for searchTerm, files := range records.FileMap {
-				f, err := os.OpenFile(outputMap[searchTerm], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				if err != nil {
-					log.Errorf("error opening file (name: %s), err: %v", outFiles[0], err)
+			for _, file := range files {
+				if err := ctx.Err(); err != nil {
+					abort()
 					return err
 				}
-				defer func() {
-					if err := f.Close(); err != nil {
-						log.Errorf("error closing file (name: %s), err: %v", outFiles[0], err)
-						panic(err)
-					}
-				}()
-				for _, file := range files {
-					if _, err := f.Write(file.Contents.Bytes()); err != nil {
-						log.Errorf("error writing to file (name: %s), err: %v", outFiles[0], err)
-						panic(err)
-					}
+				if _, err := f.Write(file.Contents.Bytes()); err != nil {
+					log.Errorf("error writing to file (name: %s), err: %v", name, err)
+					abort()
+					return err
 				}
 			}
 		}
+		for name, f := range opened {
+			if err := f.Close(); err != nil {
+				log.Errorf("error closing file (name: %s), err: %v", name, err)
+				return err
+			}
+		}
 		return nil
 	},
 }
 
+// printPlan writes the entries a plan would extract, with their local
+// header offsets and sizes, followed by the merged ranges it would fetch and
+// totals. s holds the requests already made to read the directory.
+func printPlan(w io.Writer, plan *zipfile.Plan, s zipfile.Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "OFFSET\tCOMPRESSED\tSIZE\tFETCH\t NAME\n")
+	for _, e := range plan.Entries {
+		var fetch int64
+		for _, f := range e.Fetches {
+			fetch += f.Size()
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t %s\n", e.HeaderOffset, e.CompressedSize64, e.UncompressedSize64, fetch, e.Name)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ranges:")
+	var merged int64
+	for _, r := range plan.Ranges {
+		fmt.Fprintf(w, "  bytes=%d-%d (%s)\n", r.Start, r.End, formatBytes(r.Size()))
+		merged += r.Size()
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "entries:   %d\n", len(plan.Entries))
+	fmt.Fprintf(w, "requests:  %d GET, fetching %d bytes (%s)\n", plan.Requests, plan.Bytes, formatBytes(plan.Bytes))
+	fmt.Fprintf(w, "merged:    %d ranges, %d bytes (%s)\n", len(plan.Ranges), merged, formatBytes(merged))
+	if s.ObjectSize > 0 {
+		fmt.Fprintf(w, "archive:   %s, of which %.2f%% would be fetched\n", formatBytes(s.ObjectSize), 100*float64(plan.Bytes)/float64(s.ObjectSize))
+	}
+	fmt.Fprintf(w, "directory: %d HEAD and %d GET requests, %s already fetched\n", s.HeadRequests, s.GetRequests, formatBytes(s.BytesFetched))
+	return nil
+}
+
+// openExtractor opens the archive named by the --bucket and --key flags,
+// preceded by the segments named with --parts if it is split.
+func openExtractor(ctx context.Context) (*zipfile.FileExtractor, error) {
+	if len(parts) > 0 {
+		keys := append(append([]string{}, parts...), key)
+		return zipfile.NewSplitFileExtractorWithContext(ctx, bucket, keys)
+	}
+	return zipfile.OpenFileExtractorWithContext(ctx, bucket, key)
+}
+
+// appendFile is an output file opened for appending, which can be put back
+// the way it was before zipspy wrote to it.
+type appendFile struct {
+	*os.File
+	created bool  // the file did not exist before
+	size    int64 // size of the file before writing
+}
+
+func openAppend(name string) (*appendFile, error) {
+	a := &appendFile{}
+	if fi, err := os.Stat(name); err == nil {
+		a.size = fi.Size()
+	} else if os.IsNotExist(err) {
+		a.created = true
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.File = f
+	return a, nil
+}
+
+// abort closes the file and undoes the writes to it, removing it if it was
+// created or truncating it to its previous size.
+func (a *appendFile) abort() error {
+	a.File.Close()
+	if a.created {
+		return os.Remove(a.Name())
+	}
+	return os.Truncate(a.Name(), a.size)
+}
+
 func init() {
 	rootCmd.AddCommand(extractCmd)
-	extractCmd.PersistentFlags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object)")
+	extractCmd.PersistentFlags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object), or outer.zip!/inner.zip for a nested archive")
 	extractCmd.PersistentFlags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
 	extractCmd.PersistentFlags().StringSliceVarP(&outFiles, "out", "o", []string{}, "name(s) of the file(s) to write output to")
 	extractCmd.PersistentFlags().StringSliceVarP(&files, "file", "f", []string{}, "(required) names of the files/paths to extract (e.g. plan.txt, /path/to/plan.txt, /directory)")
+	extractCmd.PersistentFlags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	extractCmd.PersistentFlags().BoolVar(&planOnly, "plan", false, "only read the archive's directory and print the entries and byte ranges that would be fetched")
+	extractCmd.PersistentFlags().StringVar(&maxBytes, "max-bytes", "", "refuse to extract if more than this many bytes would be fetched, e.g. 500M")
+	extractCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "don't show a progress bar (it is only shown when stderr is a terminal)")
+	extractCmd.PersistentFlags().StringVar(&byteRange, "range", "", "only extract bytes START-END (inclusive) of each file; START- and -SUFFIX are also accepted")
 }
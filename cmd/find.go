@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alec-rabold/zipspy/pkg/aws"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var findFiles, findSuffixes []string
+var findConcurrency int
+var findOutDir string
+
+var findCmd = &cobra.Command{
+	Use:   "find s3://bucket/prefix/",
+	Short: "Find entries in the S3 archives under a prefix",
+	Long: `Lists the objects under the prefix and reads only the directory of each
+	archive, several at a time, printing the archive, name, size, CRC-32 and
+	modification time of every entry whose name contains one of the strings
+	given with -f. Only keys ending in .zip are searched unless --suffix says
+	otherwise; with --suffix "" every object is tried. With -o, the matching
+	entries are also extracted, below DIR/<key>/.
+
+	ex:
+	zipspy find s3://myBucket/builds/ -f libfoo.so
+	zipspy find s3://myBucket/builds/2020- -f libfoo.so.1.2 -j 64
+	zipspy find s3://myBucket/layers/ --suffix .tar.gz -f etc/os-release
+	zipspy find s3://myBucket/builds/ -f config/app.yaml -o found`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(findFiles) == 0 || findConcurrency < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		bucket, prefix, err := parseS3Prefix(args[0])
+		if err != nil {
+			return err
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		// one client for every archive, so credentials are only resolved
+		// once
+		client := aws.NewClient()
+		objects, err := client.ListObjects(ctx, bucket, prefix)
+		if err != nil {
+			log.Errorf("error listing archives (bucket: %s)(prefix: %s), err: %v", bucket, prefix, err)
+			return err
+		}
+		var keys []string
+		for _, o := range objects {
+			if hasSuffix(*o.Key, findSuffixes) {
+				keys = append(keys, *o.Key)
+			}
+		}
+
+		var mu sync.Mutex // guards stdout, failed and total
+		var failed int
+		var total zipfile.Stats
+		sem := make(chan struct{}, findConcurrency)
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			key := key
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				a, found, err := findInArchive(ctx, client, bucket, key)
+				mu.Lock()
+				defer mu.Unlock()
+				if a != nil {
+					addStats(&total, a.Stats())
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Warnf("error reading archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+					}
+					failed++
+					return
+				}
+				for _, e := range found {
+					fmt.Printf("s3://%s/%s\t%s\t%d\t%08x\t%s\n", bucket, key, e.Name,
+						e.UncompressedSize64, e.CRC32, e.Modified.Format("2006-01-02 15:04"))
+				}
+			}()
+		}
+		wg.Wait()
+		if showStats {
+			writeStats(os.Stderr, total)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d archives could not be read", failed, len(keys))
+		}
+		return nil
+	},
+}
+
+// findInArchive returns the entries of an archive matching -f, after
+// extracting them if -o was given.
+func findInArchive(ctx context.Context, client *aws.Client, bucket, key string) (*zipfile.Archive, []*zipfile.Entry, error) {
+	a, err := zipfile.Open(ctx, zipfile.S3(bucket, key), zipfile.WithClient(client))
+	if err != nil {
+		return nil, nil, err
+	}
+	sel := zipfile.Match(findFiles...)
+	var found []*zipfile.Entry
+	for _, e := range a.Files() {
+		if sel(e) {
+			found = append(found, e)
+		}
+	}
+	if findOutDir != "" && len(found) > 0 {
+		dir := filepath.Join(findOutDir, filepath.FromSlash(path.Clean("/"+key)))
+		if err := a.Extract(ctx, sel, zipfile.DirSink(dir)); err != nil {
+			return a, nil, err
+		}
+	}
+	return a, found, nil
+}
+
+// parseS3Prefix splits s3://bucket/prefix into the bucket and the prefix,
+// which may be empty.
+func parseS3Prefix(uri string) (bucket, prefix string, err error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		return "", "", fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix", uri)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(uri, "s3://"), "/", 2)
+	if parts[0] == "" {
+		return "", "", fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix", uri)
+	}
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	return parts[0], prefix, nil
+}
+
+// hasSuffix reports whether key ends with any of suffixes, ignoring case,
+// or whether no suffixes (or only empty ones) are given.
+func hasSuffix(key string, suffixes []string) bool {
+	if len(suffixes) == 0 {
+		return true
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(strings.ToLower(key), strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// addStats adds the counts in s to total.
+func addStats(total *zipfile.Stats, s zipfile.Stats) {
+	total.HeadRequests += s.HeadRequests
+	total.GetRequests += s.GetRequests
+	total.BytesFetched += s.BytesFetched
+	total.BytesDecompressed += s.BytesDecompressed
+	total.ObjectSize += s.ObjectSize
+	total.HeadTime += s.HeadTime
+	total.EOCDTime += s.EOCDTime
+	total.DirectoryTime += s.DirectoryTime
+	total.BodyTime += s.BodyTime
+}
+
+func init() {
+	rootCmd.AddCommand(findCmd)
+	findCmd.Flags().StringSliceVarP(&findFiles, "file", "f", []string{}, "(required) find entries whose paths contain these strings")
+	findCmd.Flags().StringSliceVar(&findSuffixes, "suffix", []string{".zip"}, "only search keys ending with one of these")
+	findCmd.Flags().IntVarP(&findConcurrency, "concurrency", "j", 16, "number of archives to read at once")
+	findCmd.Flags().StringVarP(&findOutDir, "out", "o", "", "also extract the matching entries, below DIR/<key>/")
+}
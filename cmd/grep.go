@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// binaryCheckLen is how much of an entry is looked at for NUL bytes to
+// decide whether it is binary, as grep does.
+const binaryCheckLen = 8192
+
+var grepFiles []string
+var grepIgnoreCase, grepList, grepCount, grepText bool
+var grepAfter, grepBefore, grepContext, grepMaxCount, grepConcurrency int
+
+var grepCmd = &cobra.Command{
+	Use:   "grep PATTERN",
+	Short: "Search the contents of the files in an S3 zip archive",
+	Long: `Decompresses the entries of the archive (or those whose paths contain one
+	of the strings given with -f) as they are fetched, without writing them
+	anywhere, and prints the lines matching the regular expression PATTERN
+	as ENTRY:LINE:TEXT. Several entries are fetched at once (see -j), but
+	results are printed in the archive's order. Binary entries are reported
+	with a single line unless -a is given. With -m, the rest of an entry is
+	neither fetched nor decompressed once enough lines have matched. Exits
+	with status 1 if no line matched, and 2 if there was an error.
+
+	ex:
+	zipspy grep -b myBucket -k logs.zip 'connection refused'
+	zipspy grep -b myBucket -k logs.zip -f 2020-06/ -i -C 2 'timeout|deadline'
+	zipspy grep -b myBucket -k logs.zip -l ERROR
+	zipspy grep -b myBucket -k logs.zip -c -f .log 'status=5[0-9][0-9]'
+	zipspy grep -b myBucket -k 'bundle.zip!/service-a.zip' -m 1 password`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{resultStatus: "1"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" || grepConcurrency < 1 {
+			cmd.Usage()
+			os.Exit(errorStatus(cmd))
+		}
+		expr := args[0]
+		if grepIgnoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q, err: %v", args[0], err)
+		}
+		g := &grepper{
+			re:     re,
+			before: grepBefore,
+			after:  grepAfter,
+			max:    grepMaxCount,
+			list:   grepList,
+			count:  grepCount,
+			text:   grepText,
+			w:      bufio.NewWriter(os.Stdout),
+		}
+		if cmd.Flags().Changed("context") {
+			if !cmd.Flags().Changed("before-context") {
+				g.before = grepContext
+			}
+			if !cmd.Flags().Changed("after-context") {
+				g.after = grepContext
+			}
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		opts := []zipfile.Option{zipfile.WithConcurrency(grepConcurrency)}
+		if len(parts) > 0 {
+			opts = append(opts, zipfile.WithSegments(parts...))
+		}
+		a, err := zipfile.Open(ctx, zipfile.S3(bucket, key), opts...)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		var sel zipfile.Selector = func(e *zipfile.Entry) bool { return !e.Mode().IsDir() }
+		if len(grepFiles) > 0 {
+			match := zipfile.Match(grepFiles...)
+			sel = func(e *zipfile.Entry) bool { return !e.Mode().IsDir() && match(e) }
+		}
+		err = a.Extract(ctx, sel, g)
+		if ferr := g.w.Flush(); err == nil {
+			err = ferr
+		}
+		if showStats {
+			writeStats(os.Stderr, a.Stats())
+		}
+		if err != nil {
+			log.Errorf("error searching archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		if !g.matched {
+			return resultFailed(cmd)
+		}
+		return nil
+	},
+}
+
+// grepper is a zipfile.Sink that prints the lines of each entry matching re.
+type grepper struct {
+	re            *regexp.Regexp
+	before, after int  // lines of context
+	max           int  // matches after which to stop reading an entry, if > 0
+	list          bool // print only the names of matching entries
+	count         bool // print only the number of matching lines
+	text          bool // treat binary entries as text
+	w             *bufio.Writer
+
+	matched bool // some line of some entry matched
+	printed bool // some line has been printed, for context separators
+}
+
+// contextLine is a line held back as leading context.
+type contextLine struct {
+	n    int
+	text []byte
+}
+
+// Put searches one entry, stopping once max lines have matched and their
+// trailing context has been printed.
+func (g *grepper) Put(e *zipfile.Entry, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	binary := false
+	if !g.text {
+		head, _ := br.Peek(binaryCheckLen)
+		binary = isBinary(head)
+	}
+	lines := !g.list && !g.count && !binary // whether lines are printed
+
+	var held []contextLine // up to g.before lines preceding the next match
+	last := 0              // number of the last line printed
+	afterLeft := 0         // lines of trailing context still to print
+	matches := 0
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		text := bytes.TrimSuffix(line, []byte("\n"))
+		done := g.max > 0 && matches >= g.max
+		switch {
+		case !done && g.re.Match(text):
+			matches++
+			g.matched = true
+			if g.list {
+				fmt.Fprintln(g.w, e.Name)
+				return g.w.Flush()
+			}
+			if binary && !g.count {
+				fmt.Fprintf(g.w, "Binary file %s matches\n", e.Name)
+				return g.w.Flush()
+			}
+			if !lines {
+				break
+			}
+			first := n
+			if len(held) > 0 {
+				first = held[0].n
+			}
+			if (g.before > 0 || g.after > 0) && g.printed && (last == 0 || first > last+1) {
+				fmt.Fprintln(g.w, "--")
+			}
+			for _, c := range held {
+				fmt.Fprintf(g.w, "%s-%d-%s\n", e.Name, c.n, c.text)
+			}
+			held = held[:0]
+			fmt.Fprintf(g.w, "%s:%d:%s\n", e.Name, n, text)
+			last, afterLeft, g.printed = n, g.after, true
+		case lines && afterLeft > 0:
+			fmt.Fprintf(g.w, "%s-%d-%s\n", e.Name, n, text)
+			last = n
+			afterLeft--
+		case lines && g.before > 0:
+			if len(held) == g.before {
+				held = append(held[:0], held[1:]...)
+			}
+			held = append(held, contextLine{n, text})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if g.max > 0 && matches >= g.max && afterLeft == 0 {
+			break
+		}
+	}
+	if g.count {
+		fmt.Fprintf(g.w, "%s:%d\n", e.Name, matches)
+	}
+	return g.w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(grepCmd)
+	grepCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object), or outer.zip!/inner.zip for a nested archive")
+	grepCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	grepCmd.Flags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	grepCmd.Flags().StringSliceVarP(&grepFiles, "file", "f", []string{}, "only search entries whose paths contain these strings (default all)")
+	grepCmd.Flags().BoolVarP(&grepIgnoreCase, "ignore-case", "i", false, "ignore case when matching")
+	grepCmd.Flags().BoolVarP(&grepList, "files-with-matches", "l", false, "print only the names of entries with a matching line")
+	grepCmd.Flags().BoolVarP(&grepCount, "count", "c", false, "print only the number of matching lines of each entry")
+	grepCmd.Flags().BoolVarP(&grepText, "text", "a", false, "search binary entries as if they were text")
+	grepCmd.Flags().IntVarP(&grepAfter, "after-context", "A", 0, "print this many lines after each match")
+	grepCmd.Flags().IntVarP(&grepBefore, "before-context", "B", 0, "print this many lines before each match")
+	grepCmd.Flags().IntVarP(&grepContext, "context", "C", 0, "print this many lines before and after each match")
+	grepCmd.Flags().IntVarP(&grepMaxCount, "max-count", "m", 0, "stop reading an entry after this many matching lines")
+	grepCmd.Flags().IntVarP(&grepConcurrency, "concurrency", "j", 4, "number of entries to fetch at once")
+}
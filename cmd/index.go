@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alec-rabold/zipspy/pkg/flateindex"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var indexSpan int64
+var indexOut string
+
+var indexCmd = &cobra.Command{
+	Use:   "index ENTRY",
+	Short: "Build a seek index for a compressed file in an S3 zip archive",
+	Long: `Decompresses the Deflate entry named ENTRY once and records a checkpoint
+	every --span MiB of uncompressed data. With the index, cat --range can
+	resume decompression from the nearest checkpoint and download only the
+	compressed bytes it needs.
+
+	The index is uploaded next to the archive (at <key>.zidx/<entry>) unless
+	--out names a local file to write it to.
+
+	ex:
+	zipspy index -b myBucket -k myKey logs/server.log
+	zipspy cat -b myBucket -k myKey logs/server.log --sidecar --range -4096
+	zipspy index -b myBucket -k myKey logs/server.log --span 4 -o server.log.zidx
+	zipspy cat -b myBucket -k myKey logs/server.log --index server.log.zidx --range -4096`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 || bucket == "" || key == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		z, err := openExtractor(ctx)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		defer printStats(z)
+		z, file, err := findEntry(z, args[0])
+		if err != nil {
+			return err
+		}
+		idx, err := z.BuildIndex(file, indexSpan<<20)
+		if err != nil {
+			log.Errorf("error building index (name: %s), err: %v", file.Name, err)
+			return err
+		}
+		if indexOut == "" {
+			if err := z.SaveIndex(file, idx); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "wrote %d checkpoints to s3://%s/%s\n", len(idx.Checkpoints), bucket, z.IndexKey(file))
+			return nil
+		}
+		f, err := os.Create(indexOut)
+		if err != nil {
+			log.Errorf("error opening file (name: %s), err: %v", indexOut, err)
+			return err
+		}
+		if _, err := idx.WriteTo(f); err != nil {
+			f.Close()
+			os.Remove(indexOut)
+			log.Errorf("error writing to file (name: %s), err: %v", indexOut, err)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d checkpoints to %s\n", len(idx.Checkpoints), indexOut)
+		return nil
+	},
+}
+
+// loadIndex reads a local index file written by the index command.
+func loadIndex(name string) (*flateindex.Index, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return flateindex.ReadIndex(f)
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object)")
+	indexCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	indexCmd.Flags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	indexCmd.Flags().Int64Var(&indexSpan, "span", flateindex.DefaultSpan>>20, "distance between checkpoints, in MiB of uncompressed data")
+	indexCmd.Flags().StringVarP(&indexOut, "out", "o", "", "write the index to this local file instead of next to the archive")
+}
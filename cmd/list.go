@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var listFiles []string
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the files in an S3 archive",
+	Long: `Reads only the archive's directory (the central directory of a zip, or the
+	table of contents of a stargz/eStargz tar.gz) and prints the mode, size,
+	modification time and name of each entry. With -f, only entries whose
+	names contain one of the given strings are listed.
+
+	ex:
+	zipspy list -b myBucket -k myKey
+	zipspy list -b myBucket -k layer.tar.gz -f etc/
+	zipspy list -b myBucket -k 'bundle.zip!/service-a.zip'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		z, err := openExtractor(ctx)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		defer printStats(z)
+		zFiles, err := z.Files()
+		if err != nil {
+			log.Errorf("error reading archive directory (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, f := range zFiles {
+			if len(listFiles) > 0 && !containsAny(f.Name, listFiles) {
+				continue
+			}
+			fmt.Fprintf(w, "%v\t%d\t %s\t %s\n", f.Mode(), f.UncompressedSize64,
+				f.Modified.Format("2006-01-02 15:04"), f.Name)
+		}
+		return w.Flush()
+	},
+}
+
+// containsAny reports whether name contains any of terms, matching entries
+// the same way extract's -f flag does.
+func containsAny(name string, terms []string) bool {
+	for _, t := range terms {
+		if strings.Contains(name, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object), or outer.zip!/inner.zip for a nested archive")
+	listCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	listCmd.Flags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	listCmd.Flags().StringSliceVarP(&listFiles, "file", "f", []string{}, "only list files whose paths contain these strings")
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+const (
+	barWidth     = 24
+	nameWidth    = 32
+	drawInterval = 100 * time.Millisecond
+)
+
+// progressBar draws the progress of an extraction on a terminal, as one
+// line that is redrawn in place:
+//
+//	[==========>             ]  45%  11.5/25.6 MiB  3.2 MiB/s  ETA 4s  logs/server.log 1.5/8.0 MiB
+//
+// It implements zipfile.Progress.
+type progressBar struct {
+	w     io.Writer
+	start time.Time
+	drawn time.Time // when the line was last drawn
+
+	entries   int // entries to be extracted
+	completed int
+	total     int64 // bytes to be extracted
+	done      int64 // bytes extracted so far
+	fetched   int64 // bytes downloaded so far
+
+	name      string // entry being extracted
+	entrySize int64
+	entryDone int64
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w}
+}
+
+// stderrIsTerminal reports whether stderr is a terminal rather than a file
+// or pipe.
+func stderrIsTerminal() bool {
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressBar) Planned(entries []*reader.FileHeader, size int64) {
+	p.start = time.Now()
+	p.entries, p.total = len(entries), size
+}
+
+func (p *progressBar) Started(entry *reader.FileHeader, size int64) {
+	p.name, p.entrySize, p.entryDone = entry.Name, size, 0
+	p.draw(true)
+}
+
+func (p *progressBar) Fetched(n int64) {
+	p.fetched += n
+	p.draw(false)
+}
+
+func (p *progressBar) Decompressed(entry *reader.FileHeader, n int64) {
+	p.done += n
+	p.entryDone += n
+	p.draw(false)
+}
+
+func (p *progressBar) Completed(entry *reader.FileHeader, err error) {
+	if err != nil {
+		fmt.Fprintln(p.w) // keep the error message that follows on its own line
+		return
+	}
+	p.completed++
+	p.draw(true)
+	if p.completed == p.entries {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *progressBar) draw(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.drawn) < drawInterval {
+		return
+	}
+	p.drawn = now
+
+	frac := 1.0
+	if p.total > 0 {
+		frac = float64(p.done) / float64(p.total)
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	filled := int(frac * barWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	line := fmt.Sprintf("[%s] %3.0f%%  %s/%s", bar, frac*100, formatBytes(p.done), formatBytes(p.total))
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0.5 {
+		line += fmt.Sprintf("  %s/s", formatBytes(int64(float64(p.fetched)/elapsed)))
+		if rate := float64(p.done) / elapsed; rate > 0 && p.done < p.total {
+			eta := time.Duration(float64(p.total-p.done)/rate) * time.Second
+			line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+		}
+	}
+	name := p.name
+	if len(name) > nameWidth {
+		name = "..." + name[len(name)-nameWidth+3:]
+	}
+	line += fmt.Sprintf("  %s %s/%s", name, formatBytes(p.entryDone), formatBytes(p.entrySize))
+	fmt.Fprintf(p.w, "\r%s\033[K", line)
+}
+
+// formatBytes formats a byte count for people, as in 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var replaceOutput string
+
+var replaceCmd = &cobra.Command{
+	Use:   "replace NAME FILE [NAME FILE]...",
+	Short: "Replace entries of an S3 zip archive with local files without downloading it",
+	Long: `Replaces the contents of each named entry of a zip archive in S3 with
+	those of a local file, which also gives the entry its modification time
+	and mode. The entry is compressed with its method as before when
+	zipspy can write it (Store and Deflate are built in) and with Deflate
+	otherwise. The new archive is written as rm writes it: the bytes of the
+	other entries are copied within S3, the old contents are left out, and
+	only the new contents and a rebuilt central directory are uploaded.
+	Without -o, the archive is replaced atomically, provided it hasn't
+	changed since it was read.
+
+	ex:
+	zipspy replace -b myBucket -k release.zip config/app.yaml ./app.yaml
+	zipspy replace -b myBucket -k release.zip bin/server ./server README.md ./README.md -o s3://myBucket/release-2.zip`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" || len(args) == 0 || len(args)%2 != 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		_, err := updateArchive(cmd, replaceOutput, func(a *zipfile.Archive, u *zipfile.Update) error {
+			for i := 0; i < len(args); i += 2 {
+				if err := replaceEntry(u, args[i], args[i+1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return err
+	},
+}
+
+// replaceEntry replaces the entry name with the contents of the local file
+// at p.
+func replaceEntry(u *zipfile.Update, name, p string) error {
+	removed := u.Remove(zipfile.Names(name))
+	if len(removed) == 0 {
+		return fmt.Errorf("%s is not in s3://%s/%s (use append to add it)", name, bucket, key)
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", p)
+	}
+	fh, err := reader.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	fh.Name = name
+	fh.Comment = removed[0].Comment
+	if reader.HasCompressor(removed[0].Method) {
+		fh.Method = removed[0].Method
+	}
+	w, err := u.Create(fh)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("replacing: %s\n", name)
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		log.Errorf("error adding file (name: %s), err: %v", p, err)
+		return err
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(replaceCmd)
+	replaceCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object)")
+	replaceCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	replaceCmd.Flags().StringVarP(&replaceOutput, "output", "o", "", "write the new archive to s3://bucket/key instead of replacing the original")
+}
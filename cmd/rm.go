@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	"github.com/spf13/cobra"
+)
+
+var rmOutput string
+var rmFiles []string
+
+var rmCmd = &cobra.Command{
+	Use:   "rm [NAME...]",
+	Short: "Delete entries from an S3 zip archive without downloading it",
+	Long: `Deletes the entries with the given names, and those whose paths contain
+	one of the strings given with -f, from a zip archive in S3. The new
+	archive is written with a multipart upload in which the bytes of the
+	entries kept are copied within S3, so they never pass through this
+	machine, apart from runs of them smaller than S3's 5 MiB minimum part
+	size; only a rebuilt central directory is uploaded. The deleted entries'
+	bytes are not in the new archive at all. Without -o, the archive is
+	replaced atomically, provided it hasn't changed since it was read; if
+	the bucket has versioning enabled, the old version still holds the
+	deleted entries until it is deleted too.
+
+	ex:
+	zipspy rm -b myBucket -k release.zip config/secrets.env
+	zipspy rm -b myBucket -k release.zip -f .DS_Store -f __MACOSX/
+	zipspy rm -b myBucket -k release.zip -f tmp/ -o s3://myBucket/release-clean.zip`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" || len(args) == 0 && len(rmFiles) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		res, err := updateArchive(cmd, rmOutput, func(a *zipfile.Archive, u *zipfile.Update) error {
+			var removed []*zipfile.Entry
+			for _, name := range args {
+				r := u.Remove(zipfile.Names(name))
+				if len(r) == 0 {
+					return fmt.Errorf("%s is not in s3://%s/%s", name, bucket, key)
+				}
+				removed = append(removed, r...)
+			}
+			if len(rmFiles) > 0 {
+				removed = append(removed, u.Remove(zipfile.Match(rmFiles...))...)
+			}
+			if len(removed) == 0 {
+				return fmt.Errorf("no entries of s3://%s/%s match", bucket, key)
+			}
+			for _, e := range removed {
+				fmt.Printf("deleting: %s\n", e.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if rmOutput == "" && res.VersionID != "" {
+			fmt.Printf("The previous version of s3://%s/%s still holds the deleted entries\n", bucket, key)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rmCmd)
+	rmCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object)")
+	rmCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	rmCmd.Flags().StringSliceVarP(&rmFiles, "file", "f", []string{}, "also delete the entries whose paths contain these strings")
+	rmCmd.Flags().StringVarP(&rmOutput, "output", "o", "", "write the new archive to s3://bucket/key instead of replacing the original")
+}
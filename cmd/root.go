@@ -1,9 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/alec-rabold/zipspy/pkg/aws"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -15,6 +26,7 @@ var (
 )
 
 var cfgFile string
+var timeout time.Duration
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -29,18 +41,73 @@ example:
 zipspy extract -b myBucket -k myKey -f plan.txt
 zipspy extract -b myBucket -k myKey -f plan.txt -o my-plan.txt
 zipspy extract -b myBucket -k myKey -f plan1.txt, plan2.txt, path/to/plan3.txt, /directory`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := setAWSConfig(); err != nil {
+			return err
+		}
+		return setLimits()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute(version string) {
 	VERSION = version
 
-	if err := rootCmd.Execute(); err != nil {
+	// SIGINT and SIGTERM cancel in-flight requests so that commands can
+	// clean up; a second signal kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		if errors.Is(err, errResult) {
+			os.Exit(1)
+		}
 		fmt.Println(err)
-		os.Exit(1)
+		switch {
+		case errors.Is(err, aws.ErrRequesterPays):
+			fmt.Fprintln(os.Stderr, "if the bucket is requester pays, use --request-payer (access is also denied to keys that don't exist, without s3:ListBucket)")
+		case errors.Is(err, aws.ErrSSECustomerKey):
+			fmt.Fprintln(os.Stderr, "the object is encrypted with SSE-C, so give its key with --sse-c-key or --sse-c-key-file")
+		case errors.Is(err, aws.ErrSourceChanged):
+			fmt.Fprintln(os.Stderr, "the archive was changed by someone else while it was being updated; nothing was written, so run the command again")
+		}
+		cmd, _, err := rootCmd.Find(os.Args[1:])
+		if err != nil {
+			cmd = rootCmd
+		}
+		os.Exit(errorStatus(cmd))
 	}
 }
 
+// resultStatus annotates commands that exit with status 1 to give a result,
+// such as grep finding nothing.
+const resultStatus = "zipspy/result-status"
+
+// errResult is returned by commands annotated with resultStatus whose
+// result is a failure, such as grep finding nothing, to exit with status 1
+// without printing anything more. Use resultFailed to return it.
+var errResult = errors.New("zipspy: result failed")
+
+// resultFailed returns errResult, keeping cobra from reporting it as an
+// error.
+func resultFailed(cmd *cobra.Command) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return errResult
+}
+
+// errorStatus returns the status to exit with when cmd fails: 1, or 2 for
+// commands annotated with resultStatus, as for grep(1) and diff(1).
+func errorStatus(cmd *cobra.Command) int {
+	if _, ok := cmd.Annotations[resultStatus]; ok {
+		return 2
+	}
+	return 1
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -49,12 +116,146 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.zipspy.yaml)")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "give up after this long, e.g. 30s or 5m (default no limit; not applied to serve)")
+	rootCmd.PersistentFlags().BoolVar(&showStats, "stats", false, "print the requests made, bytes transferred and time taken to stderr (not applied to serve)")
+	rootCmd.PersistentFlags().String("limit-rate", "", "limit downloads to this many bytes per second, e.g. 500K or 2M (default no limit)")
+	rootCmd.PersistentFlags().Float64("max-rps", 0, "send at most this many S3 requests per second (default no limit)")
+	viper.BindPFlag("limit-rate", rootCmd.PersistentFlags().Lookup("limit-rate"))
+	viper.BindPFlag("max-rps", rootCmd.PersistentFlags().Lookup("max-rps"))
+
+	// S3 connection settings, which override the environment and shared
+	// AWS config files.
+	rootCmd.PersistentFlags().String("endpoint-url", "", "URL of an S3-compatible endpoint, e.g. https://minio.example.com:9000")
+	rootCmd.PersistentFlags().String("region", "", "AWS region (default from AWS_REGION or the shared config)")
+	rootCmd.PersistentFlags().String("profile", "", "profile from the shared AWS config and credentials files")
+	rootCmd.PersistentFlags().Bool("path-style", false, "address buckets as ENDPOINT/BUCKET rather than BUCKET.ENDPOINT, as MinIO and Ceph usually require")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "PEM file of CA certificates to trust for the endpoint")
+	rootCmd.PersistentFlags().Bool("no-verify-ssl", false, "don't verify the endpoint's TLS certificate")
+	rootCmd.PersistentFlags().String("role-arn", "", "ARN of an IAM role to assume for S3 requests, e.g. in another account")
+	rootCmd.PersistentFlags().String("external-id", "", "external ID to pass when assuming --role-arn")
+	rootCmd.PersistentFlags().String("role-session-name", "", "session name to use when assuming --role-arn (default zipspy-TIMESTAMP)")
+	rootCmd.PersistentFlags().String("sts-endpoint-url", "", "URL of the STS endpoint used to assume --role-arn")
+	rootCmd.PersistentFlags().Bool("request-payer", false, "accept the charges for requests to requester pays buckets")
+	rootCmd.PersistentFlags().String("sse-c-key", "", "base64 encoded 256-bit key of objects encrypted with SSE-C")
+	rootCmd.PersistentFlags().String("sse-c-key-file", "", "file holding the key of objects encrypted with SSE-C, as 32 raw bytes or base64")
+	for _, name := range []string{"endpoint-url", "region", "profile", "path-style", "ca-bundle", "no-verify-ssl",
+		"role-arn", "external-id", "role-session-name", "sts-endpoint-url", "request-payer", "sse-c-key", "sse-c-key-file"} {
+		viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(name))
+	}
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// commandContext returns the context for a command's requests, which is
+// cancelled by SIGINT or SIGTERM and after --timeout, if set.
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(cmd.Context(), timeout)
+	}
+	return context.WithCancel(cmd.Context())
+}
+
+// setAWSConfig applies the S3 connection settings, from flags or the config
+// file, to every client the process creates.
+func setAWSConfig() error {
+	cfg := aws.Config{
+		Endpoint:           viper.GetString("endpoint-url"),
+		Region:             viper.GetString("region"),
+		Profile:            viper.GetString("profile"),
+		PathStyle:          viper.GetBool("path-style"),
+		CABundle:           viper.GetString("ca-bundle"),
+		InsecureSkipVerify: viper.GetBool("no-verify-ssl"),
+		RoleARN:            viper.GetString("role-arn"),
+		ExternalID:         viper.GetString("external-id"),
+		RoleSessionName:    viper.GetString("role-session-name"),
+		STSEndpoint:        viper.GetString("sts-endpoint-url"),
+		RequestPayer:       viper.GetBool("request-payer"),
+	}
+	if cfg.RoleARN == "" && (cfg.ExternalID != "" || cfg.RoleSessionName != "") {
+		return errors.New("--external-id and --role-session-name need --role-arn")
+	}
+	key, err := sseKey(viper.GetString("sse-c-key"), viper.GetString("sse-c-key-file"))
+	if err != nil {
+		return err
+	}
+	cfg.SSECustomerKey = key
+	if reflect.DeepEqual(cfg, aws.Config{}) {
+		return nil
+	}
+	return aws.SetDefaultConfig(cfg)
+}
+
+// sseKey returns the SSE-C key given in base64, or in a file either raw or
+// in base64.
+func sseKey(b64, file string) ([]byte, error) {
+	if b64 != "" && file != "" {
+		return nil, errors.New("give only one of --sse-c-key and --sse-c-key-file")
+	}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSE-C key (name: %s), err: %w", file, err)
+		}
+		if len(b) == 32 {
+			return b, nil
+		}
+		b64 = strings.TrimSpace(string(b))
+	}
+	if b64 == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSE-C key, err: %w", err)
+	}
+	return key, nil
+}
+
+// setLimits applies the limit-rate and max-rps settings, from flags or the
+// config file, to every S3 request the process makes.
+func setLimits() error {
+	rate, err := parseBytes("limit-rate", viper.GetString("limit-rate"))
+	if err != nil {
+		return err
+	}
+	rps := viper.GetFloat64("max-rps")
+	if rps < 0 {
+		return fmt.Errorf("invalid max-rps %v", rps)
+	}
+	if rate > 0 || rps > 0 {
+		aws.SetDefaultLimiter(aws.NewLimiter(rate, rps))
+	}
+	return nil
+}
+
+// parseBytes parses the value of the named flag as a number of bytes,
+// optionally followed by K, M or G for multiples of 1024.
+func parseBytes(flag, s string) (int64, error) {
+	v := strings.TrimSpace(s)
+	if v == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch v[len(v)-1] {
+	case 'k', 'K':
+		mult = 1 << 10
+	case 'm', 'M':
+		mult = 1 << 20
+	case 'g', 'G':
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", flag, s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -73,7 +274,11 @@ func initConfig() {
 		viper.SetConfigName(".zipspy")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, as ZIPSPY_ENDPOINT_URL for
+	// endpoint-url
+	viper.SetEnvPrefix("zipspy")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/server"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var addr string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [name=]s3://bucket/key...",
+	Short: "Serve the entries of one or more S3 zip archives over HTTP",
+	Long: `Reads the central directory of each archive and serves its entries at
+	http://<addr>/<archive>/<entry path>, range-fetching and decompressing
+	them on demand. Directories are listed, and stored (uncompressed) entries
+	support HTTP Range requests. Archives are served under the base name of
+	their key unless a name is given.
+
+	ex:
+	zipspy serve s3://myBucket/archive.zip
+	zipspy serve --addr :9000 reports=s3://myBucket/results/reports.zip s3://myBucket/logs.zip
+	zipspy serve 's3://myBucket/bundle.zip!/service-a.zip'`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		archives := make(map[string]*zipfile.FS)
+		for _, arg := range args {
+			name, bucket, key, err := parseArchiveArg(arg)
+			if err != nil {
+				return err
+			}
+			if _, dup := archives[name]; dup {
+				return fmt.Errorf("archive name %q is used more than once", name)
+			}
+			fsys, err := zipfile.NewFSWithContext(cmd.Context(), bucket, key)
+			if err != nil {
+				log.Errorf("error reading archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+				return err
+			}
+			archives[name] = fsys
+			log.Infof("serving s3://%s/%s at /%s/", bucket, key, name)
+		}
+		srv := &http.Server{Addr: addr, Handler: server.New(archives)}
+		done := make(chan struct{})
+		go func() {
+			// on SIGINT or SIGTERM, give in-flight requests a moment to finish
+			defer close(done)
+			<-cmd.Context().Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		}()
+		log.Infof("listening on %s", addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+		<-done
+		return nil
+	},
+}
+
+// parseArchiveArg splits an archive argument of the form
+// [name=][s3://]bucket/key into its parts. The name defaults to the base
+// name of the key. Text before an "=" is only a name if it holds no "/" or
+// ":", so that keys such as date=2020-01-01/results.zip can be given
+// without one.
+func parseArchiveArg(arg string) (name, bucket, key string, err error) {
+	if i := strings.Index(arg, "="); i >= 0 && !strings.ContainsAny(arg[:i], "/:") {
+		if i == 0 {
+			return "", "", "", fmt.Errorf("invalid archive name in %q", arg)
+		}
+		name, arg = arg[:i], arg[i+1:]
+	}
+	bucket, key, err = parseS3URI(arg)
+	if err != nil {
+		return "", "", "", err
+	}
+	if name == "" {
+		name = path.Base(key)
+	}
+	if strings.Contains(name, "/") {
+		return "", "", "", fmt.Errorf("invalid archive name %q", name)
+	}
+	return name, bucket, key, nil
+}
+
+// parseS3URI splits s3://bucket/key (the scheme is optional) into a bucket
+// and key.
+func parseS3URI(uri string) (bucket, key string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(uri, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid S3 location %q, expected s3://bucket/key", uri)
+	}
+	return parts[0], parts[1], nil
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&addr, "addr", "a", ":8080", "address to listen on")
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import "testing"
+
+func TestParseArchiveArg(t *testing.T) {
+	tests := []struct {
+		arg               string
+		name, bucket, key string
+		wantErr           bool
+	}{
+		{arg: "s3://bucket/results/reports.zip", name: "reports.zip", bucket: "bucket", key: "results/reports.zip"},
+		{arg: "bucket/reports.zip", name: "reports.zip", bucket: "bucket", key: "reports.zip"},
+		{arg: "reports=s3://bucket/results/reports.zip", name: "reports", bucket: "bucket", key: "results/reports.zip"},
+		{arg: "reports=bucket/reports.zip", name: "reports", bucket: "bucket", key: "reports.zip"},
+		{arg: "s3://bucket/date=2020-01-01/results.zip", name: "results.zip", bucket: "bucket", key: "date=2020-01-01/results.zip"},
+		{arg: "bucket/date=2020-01-01/results.zip", name: "results.zip", bucket: "bucket", key: "date=2020-01-01/results.zip"},
+		{arg: "day=s3://bucket/date=2020-01-01/results.zip", name: "day", bucket: "bucket", key: "date=2020-01-01/results.zip"},
+		{arg: "=s3://bucket/results.zip", wantErr: true},
+		{arg: "reports=s3://bucket", wantErr: true},
+	}
+	for _, tt := range tests {
+		name, bucket, key, err := parseArchiveArg(tt.arg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseArchiveArg(%q) = %q, %q, %q; want an error", tt.arg, name, bucket, key)
+			}
+			continue
+		}
+		if err != nil || name != tt.name || bucket != tt.bucket || key != tt.key {
+			t.Errorf("parseArchiveArg(%q) = %q, %q, %q, %v; want %q, %q, %q", tt.arg, name, bucket, key, err, tt.name, tt.bucket, tt.key)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+)
+
+var showStats bool
+
+// printStats writes a report of the requests made and bytes transferred
+// for the archive to stderr, if --stats was given.
+func printStats(z *zipfile.FileExtractor) {
+	if showStats {
+		writeStats(os.Stderr, z.Stats())
+	}
+}
+
+func writeStats(w io.Writer, s zipfile.Stats) {
+	fmt.Fprintf(w, "requests:       %d HEAD, %d GET\n", s.HeadRequests, s.GetRequests)
+	fmt.Fprintf(w, "fetched:        %s of %s (%.2f%%)\n", formatBytes(s.BytesFetched), formatBytes(s.ObjectSize), s.Transferred())
+	fmt.Fprintf(w, "decompressed:   %s\n", formatBytes(s.BytesDecompressed))
+	fmt.Fprintf(w, "time:           HEAD %v, EOCD %v, directory %v, bodies %v\n",
+		s.HeadTime.Round(time.Millisecond), s.EOCDTime.Round(time.Millisecond), s.DirectoryTime.Round(time.Millisecond), s.BodyTime.Round(time.Millisecond))
+	fmt.Fprintf(w, "estimated cost: $%.6f (S3 Standard requests and internet egress)\n", s.Cost())
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var testFiles []string
+var testVerbose bool
+var testConcurrency int
+
+var testCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check the integrity of an S3 archive",
+	Long: `Like unzip -t, fetches and decompresses every entry of the archive (or
+	those whose paths contain one of the strings given with -f) without
+	writing it anywhere, checking that its contents match the CRC-32 and size
+	in the central directory and that its local header agrees with its
+	directory header. Problems are printed with the entry's name and the
+	offset of its local header, and the exit status is non-zero if there
+	were any. Several entries are fetched at once (see -j).
+
+	ex:
+	zipspy test -b myBucket -k release.zip
+	zipspy test -b myBucket -k release.zip -f bin/ -v
+	zipspy test -b myBucket -k 'bundle.zip!/service-a.zip' -j 16`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" || testConcurrency < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		opts := []zipfile.Option{zipfile.WithConcurrency(testConcurrency)}
+		if len(parts) > 0 {
+			opts = append(opts, zipfile.WithSegments(parts...))
+		}
+		a, err := zipfile.Open(ctx, zipfile.S3(bucket, key), opts...)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		var sel zipfile.Selector
+		if len(testFiles) > 0 {
+			sel = zipfile.Match(testFiles...)
+		}
+		var tested, failed int
+		err = a.Test(ctx, sel, func(e *zipfile.Entry, err error) {
+			tested++
+			if err != nil {
+				failed++
+				fmt.Println(err)
+			} else if testVerbose {
+				fmt.Printf("%s: OK\n", e.Name)
+			}
+		})
+		if showStats {
+			writeStats(os.Stderr, a.Stats())
+		}
+		if err != nil {
+			log.Errorf("error reading archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d entries of s3://%s/%s failed the test", failed, tested, bucket, key)
+		}
+		fmt.Printf("No errors detected in %d entries of s3://%s/%s\n", tested, bucket, key)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+	testCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object), or outer.zip!/inner.zip for a nested archive")
+	testCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	testCmd.Flags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	testCmd.Flags().StringSliceVarP(&testFiles, "file", "f", []string{}, "only test entries whose paths contain these strings (default all)")
+	testCmd.Flags().BoolVarP(&testVerbose, "verbose", "v", false, "also print the entries that are OK")
+	testCmd.Flags().IntVarP(&testConcurrency, "concurrency", "j", 4, "number of entries to fetch at once")
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var verifyDir, verifyPrefix string
+var verifyFiles []string
+var verifyJSON bool
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that a local directory matches an S3 archive",
+	Long: `Reads only the archive's directory and compares the regular files in it
+	with those below --dir, computing the CRC-32 and size of the local files.
+	Files in the archive but not on disk are reported as missing, files on
+	disk but not in the archive as extra, and files whose size or CRC-32
+	differ as modified. With --prefix, only the archive's entries below that
+	path are compared, with the prefix removed. Exits with status 1 if there
+	are differences, and 2 if there was an error.
+
+	ex:
+	zipspy verify -b myBucket -k release.zip --dir ./deploy
+	zipspy verify -b myBucket -k release.zip --dir /srv/app --prefix app/ --json`,
+	Annotations: map[string]string{resultStatus: "1"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bucket == "" || key == "" || verifyDir == "" {
+			cmd.Usage()
+			os.Exit(errorStatus(cmd))
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		var opts []zipfile.Option
+		if len(parts) > 0 {
+			opts = append(opts, zipfile.WithSegments(parts...))
+		}
+		a, err := zipfile.Open(ctx, zipfile.S3(bucket, key), opts...)
+		if err != nil {
+			log.Errorf("error opening archive (bucket: %s)(key: %s), err: %v", bucket, key, err)
+			return err
+		}
+		// nothing more is fetched once the directory is read
+		if showStats {
+			writeStats(os.Stderr, a.Stats())
+		}
+		r, err := verifyDirectory(a, verifyDir)
+		if err != nil {
+			log.Errorf("error reading directory (name: %s), err: %v", verifyDir, err)
+			return err
+		}
+		r.Archive = fmt.Sprintf("s3://%s/%s", bucket, key)
+		if verifyJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		} else {
+			r.print(os.Stdout)
+		}
+		if !r.OK {
+			return resultFailed(cmd)
+		}
+		return nil
+	},
+}
+
+// verifyReport is the result of comparing a directory with an archive.
+type verifyReport struct {
+	Archive  string           `json:"archive"`
+	Dir      string           `json:"dir"`
+	OK       bool             `json:"ok"`
+	Matched  int              `json:"matched"`
+	Missing  []string         `json:"missing"`
+	Extra    []string         `json:"extra"`
+	Modified []verifyModified `json:"modified"`
+}
+
+// verifyModified is a file whose contents differ from the archive's.
+type verifyModified struct {
+	Name       string `json:"name"`
+	Size       uint64 `json:"size"`
+	CRC32      string `json:"crc32,omitempty"`
+	LocalSize  int64  `json:"local_size"`
+	LocalCRC32 string `json:"local_crc32,omitempty"`
+}
+
+// verifyDirectory compares the regular files below dir with those in the
+// archive, computing CRC-32s only for local files of the right size.
+func verifyDirectory(a *zipfile.Archive, dir string) (*verifyReport, error) {
+	r := &verifyReport{Dir: dir, Missing: []string{}, Extra: []string{}, Modified: []verifyModified{}}
+	prefix := verifyPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	entries := make(map[string]*zipfile.Entry)
+	for _, e := range a.Files() {
+		if !e.Mode().IsRegular() || !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		if len(verifyFiles) > 0 && !containsAny(e.Name, verifyFiles) {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(e.Name, prefix))
+		if _, dup := entries[name]; !dup {
+			entries[name] = e
+		}
+	}
+
+	local := make(map[string]bool)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if len(verifyFiles) > 0 && !containsAny(prefix+name, verifyFiles) {
+			return nil
+		}
+		local[name] = true
+		e, ok := entries[name]
+		if !ok {
+			r.Extra = append(r.Extra, name)
+			return nil
+		}
+		m := verifyModified{Name: name, Size: e.UncompressedSize64, LocalSize: fi.Size()}
+		if e.HasCRC() {
+			m.CRC32 = fmt.Sprintf("%08x", e.CRC32)
+		}
+		if uint64(fi.Size()) == e.UncompressedSize64 {
+			if !e.HasCRC() {
+				r.Matched++
+				return nil
+			}
+			crc, err := fileCRC32(p)
+			if err != nil {
+				return err
+			}
+			if crc == e.CRC32 {
+				r.Matched++
+				return nil
+			}
+			m.LocalCRC32 = fmt.Sprintf("%08x", crc)
+		}
+		r.Modified = append(r.Modified, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range entries {
+		if !local[name] {
+			r.Missing = append(r.Missing, name)
+		}
+	}
+	sort.Strings(r.Missing)
+	r.OK = len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Modified) == 0
+	return r, nil
+}
+
+// fileCRC32 returns the CRC-32 of the contents of the named file.
+func fileCRC32(name string) (uint32, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// print writes the report as one line per difference, then a summary.
+func (r *verifyReport) print(w io.Writer) {
+	for _, name := range r.Missing {
+		fmt.Fprintf(w, "missing\t%s\n", name)
+	}
+	for _, name := range r.Extra {
+		fmt.Fprintf(w, "extra\t%s\n", name)
+	}
+	for _, m := range r.Modified {
+		if m.LocalCRC32 != "" {
+			fmt.Fprintf(w, "modified\t%s\tCRC-32 %s, archive has %s\n", m.Name, m.LocalCRC32, m.CRC32)
+		} else {
+			fmt.Fprintf(w, "modified\t%s\t%d bytes, archive has %d\n", m.Name, m.LocalSize, m.Size)
+		}
+	}
+	if r.OK {
+		fmt.Fprintf(w, "%s matches %s (%d files)\n", r.Dir, r.Archive, r.Matched)
+	} else {
+		fmt.Fprintf(w, "%d missing, %d extra and %d modified files; %d match\n", len(r.Missing), len(r.Extra), len(r.Modified), r.Matched)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVarP(&key, "key", "k", "", "(required) name of the S3 key (object), or outer.zip!/inner.zip for a nested archive")
+	verifyCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "(required) name of the S3 bucket")
+	verifyCmd.Flags().StringSliceVar(&parts, "parts", []string{}, partsUsage)
+	verifyCmd.Flags().StringVarP(&verifyDir, "dir", "d", "", "(required) local directory to compare with the archive")
+	verifyCmd.Flags().StringVar(&verifyPrefix, "prefix", "", "only compare entries below this path in the archive, e.g. app/")
+	verifyCmd.Flags().StringSliceVarP(&verifyFiles, "file", "f", []string{}, "only compare files whose paths in the archive contain these strings")
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "print the report as JSON")
+}
@@ -1,9 +1,10 @@
 module github.com/alec-rabold/zipspy
 
-go 1.13
+go 1.16
 
 require (
 	github.com/aws/aws-sdk-go v1.29.15
+	github.com/klauspost/compress v1.14.4
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/sirupsen/logrus v1.2.0
 	github.com/spf13/cobra v0.0.6
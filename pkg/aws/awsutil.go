@@ -2,48 +2,501 @@ package aws
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	log "github.com/sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
+var (
+	// ErrRequesterPays is reported with S3's access denied errors when the
+	// client does not accept requester pays charges, as a possible cause.
+	ErrRequesterPays = errors.New("access denied; if the bucket is requester pays, the request payer must be set")
+	// ErrSSECustomerKey is reported with S3's errors for requests for
+	// objects encrypted with SSE-C when the client has no SSE-C key.
+	ErrSSECustomerKey = errors.New("bad request; if the object is encrypted with a customer-provided key (SSE-C), the key must be given")
+	// ErrSourceChanged is reported with S3's precondition failed errors
+	// when UploadPartCopy or a GET is given an ETag, as the object has
+	// changed.
+	ErrSourceChanged = errors.New("the source object has changed")
+)
+
+const sseAlgorithm = "AES256"
+
 // Client is an abstraction layer for interacting with AWS services.
 type Client struct {
-	s3 s3.S3
+	s3           s3iface.S3API
+	requestPayer bool   // accept requester pays charges
+	sseKey       []byte // SSE-C key, if any
 }
 
-// NewClient creates a new AWS client, expecting that the environment variables configure the settings.
+// NewClient creates a new AWS client, expecting that the environment variables configure the settings,
+// unless SetDefaultConfig has been called.
 func NewClient() *Client {
+	if sess, cfg := getDefaultSession(); sess != nil {
+		c := NewClientWithS3(s3.New(sess))
+		c.requestPayer, c.sseKey = cfg.RequestPayer, cfg.SSECustomerKey
+		return c
+	}
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	return &Client{
-		s3: *s3.New(sess),
-	}
+	return NewClientWithS3(s3.New(sess))
+}
+
+// NewClientWithS3 creates a new AWS client that uses the given S3 client,
+// which may be configured in any way or replaced by a stub in tests.
+func NewClientWithS3(api s3iface.S3API) *Client {
+	return &Client{s3: api}
 }
 
 // GetHeadObject implements the AWS interface
-func (c *Client) GetHeadObject(ctx context.Context, bucket, key string) *s3.HeadObjectOutput {
-	output, err := c.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+func (c *Client) GetHeadObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error) {
+	return c.GetHeadObjectVersion(ctx, bucket, key, "")
+}
+
+// GetHeadObjectVersion is like GetHeadObject, but for the given version of
+// the object, or the current one if version is empty.
+func (c *Client) GetHeadObjectVersion(ctx context.Context, bucket, key, version string) (*s3.HeadObjectOutput, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return nil, err
+	}
+	input := &s3.HeadObjectInput{
+		Bucket:    &bucket,
+		Key:       &key,
+		VersionId: versionID(version),
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	output, err := c.s3.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error getting S3 head object (bucket: %s)(key: %s)%s, err: %w", bucket, key, versionTag(version), c.explainHead(ctx, input, err))
+	}
+	return output, nil
+}
+
+// GetS3ObjectWithRange implements the AWS interface. Requests and the
+// returned body are subject to the default Limiter, if any.
+func (c *Client) GetS3ObjectWithRange(ctx context.Context, bucket, key, byteRange string) (*s3.GetObjectOutput, error) {
+	return c.GetS3ObjectVersionWithRange(ctx, bucket, key, "", byteRange)
+}
+
+// GetS3ObjectVersionWithRange is like GetS3ObjectWithRange, but for the
+// given version of the object, or the current one if version is empty.
+func (c *Client) GetS3ObjectVersionWithRange(ctx context.Context, bucket, key, version, byteRange string) (*s3.GetObjectOutput, error) {
+	return c.GetS3ObjectVersionWithRangeIfMatch(ctx, bucket, key, version, "", byteRange)
+}
+
+// GetS3ObjectVersionWithRangeIfMatch is like GetS3ObjectVersionWithRange,
+// but if etag is not empty, the request fails unless the object still has
+// that ETag.
+func (c *Client) GetS3ObjectVersionWithRangeIfMatch(ctx context.Context, bucket, key, version, etag, byteRange string) (*s3.GetObjectOutput, error) {
+	limiter := getDefaultLimiter()
+	if err := limiter.waitRequest(ctx); err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{
+		Bucket:    &bucket,
+		Key:       &key,
+		Range:     &byteRange,
+		VersionId: versionID(version),
+		IfMatch:   optional(etag),
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	output, err := c.s3.GetObjectWithContext(ctx, input)
+	if err != nil {
+		var rf awserr.RequestFailure
+		if etag != "" && errors.As(err, &rf) && rf.StatusCode() == 412 {
+			err = &causeError{err, ErrSourceChanged}
+		} else {
+			err = c.explain(err)
+		}
+		return nil, fmt.Errorf("error getting S3 object (bucket: %s)(key: %s)%s(range: %s), err: %w", bucket, key, versionTag(version), byteRange, err)
+	}
+	output.Body = limiter.body(ctx, output.Body)
+	return output, nil
+}
+
+// GetS3Object implements the AWS interface
+func (c *Client) GetS3Object(ctx context.Context, bucket, key string) (*s3.GetObjectOutput, error) {
+	limiter := getDefaultLimiter()
+	if err := limiter.waitRequest(ctx); err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
-	})
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	output, err := c.s3.GetObjectWithContext(ctx, input)
 	if err != nil {
-		log.Errorf("error getting S3 head object (bucket: %s)(key: %s), err: %v", bucket, key, err)
+		return nil, fmt.Errorf("error getting S3 object (bucket: %s)(key: %s), err: %w", bucket, key, c.explain(err))
 	}
-	return output
+	output.Body = limiter.body(ctx, output.Body)
+	return output, nil
 }
 
-// GetS3ObjectWithRange implements the AWS interface
-func (c *Client) GetS3ObjectWithRange(ctx context.Context, bucket, key, byteRange string) *s3.GetObjectOutput {
-	output, err := c.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+// PutS3Object implements the AWS interface
+func (c *Client) PutS3Object(ctx context.Context, bucket, key string, body io.ReadSeeker) (*s3.PutObjectOutput, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return nil, err
+	}
+	input := &s3.PutObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
-		Range:  &byteRange,
-	})
+		Body:   body,
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	output, err := c.s3.PutObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error putting S3 object (bucket: %s)(key: %s), err: %w", bucket, key, c.explain(err))
+	}
+	return output, nil
+}
+
+// ObjectSettings are the settings of an S3 object other than its contents,
+// which CreateMultipartUpload gives the object it writes.
+type ObjectSettings struct {
+	ContentType             string
+	CacheControl            string
+	ContentDisposition      string
+	ContentEncoding         string
+	ContentLanguage         string
+	Expires                 *time.Time
+	WebsiteRedirectLocation string
+	Metadata                map[string]*string // user metadata, without the x-amz-meta- prefix
+	StorageClass            string
+	ServerSideEncryption    string // SSE-S3 or SSE-KMS; SSE-C is the client's
+	SSEKMSKeyID             string
+	Tagging                 string // URL-encoded, as GetObjectTagging returns
+}
+
+// HeadSettings returns the settings of an object given in the response to
+// a HEAD request for it. They do not include its tags, which only
+// GetObjectTagging returns.
+func HeadSettings(head *s3.HeadObjectOutput) *ObjectSettings {
+	s := &ObjectSettings{
+		ContentType:             awssdk.StringValue(head.ContentType),
+		CacheControl:            awssdk.StringValue(head.CacheControl),
+		ContentDisposition:      awssdk.StringValue(head.ContentDisposition),
+		ContentEncoding:         awssdk.StringValue(head.ContentEncoding),
+		ContentLanguage:         awssdk.StringValue(head.ContentLanguage),
+		WebsiteRedirectLocation: awssdk.StringValue(head.WebsiteRedirectLocation),
+		Metadata:                head.Metadata,
+		StorageClass:            awssdk.StringValue(head.StorageClass),
+		ServerSideEncryption:    awssdk.StringValue(head.ServerSideEncryption),
+		SSEKMSKeyID:             awssdk.StringValue(head.SSEKMSKeyId),
+	}
+	if head.Expires != nil {
+		// S3 returns the header as it was given, which may not be a date.
+		if t, err := http.ParseTime(*head.Expires); err == nil {
+			s.Expires = &t
+		}
+	}
+	return s
+}
+
+// GetObjectTagging returns the tags of the given version of an object,
+// URL-encoded as ObjectSettings.Tagging expects.
+func (c *Client) GetObjectTagging(ctx context.Context, bucket, key, version string) (string, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return "", err
+	}
+	input := &s3.GetObjectTaggingInput{
+		Bucket:    &bucket,
+		Key:       &key,
+		VersionId: versionID(version),
+	}
+	output, err := c.s3.GetObjectTaggingWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error getting S3 object tags (bucket: %s)(key: %s)%s, err: %w", bucket, key, versionTag(version), c.explain(err))
+	}
+	tags := url.Values{}
+	for _, tag := range output.TagSet {
+		tags.Add(awssdk.StringValue(tag.Key), awssdk.StringValue(tag.Value))
+	}
+	return tags.Encode(), nil
+}
+
+// CreateMultipartUpload starts a multipart upload of an object with the
+// given settings, returning its upload ID. The parts are added with
+// UploadPart and UploadPartCopy, and the object only appears once
+// CompleteMultipartUpload is called.
+func (c *Client) CreateMultipartUpload(ctx context.Context, bucket, key string, settings *ObjectSettings) (string, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return "", err
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:                  &bucket,
+		Key:                     &key,
+		ContentType:             optional(settings.ContentType),
+		CacheControl:            optional(settings.CacheControl),
+		ContentDisposition:      optional(settings.ContentDisposition),
+		ContentEncoding:         optional(settings.ContentEncoding),
+		ContentLanguage:         optional(settings.ContentLanguage),
+		Expires:                 settings.Expires,
+		WebsiteRedirectLocation: optional(settings.WebsiteRedirectLocation),
+		Metadata:                settings.Metadata,
+		StorageClass:            optional(settings.StorageClass),
+		Tagging:                 optional(settings.Tagging),
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	if input.SSECustomerKey == nil {
+		input.ServerSideEncryption = optional(settings.ServerSideEncryption)
+		input.SSEKMSKeyId = optional(settings.SSEKMSKeyID)
+	}
+	output, err := c.s3.CreateMultipartUploadWithContext(ctx, input)
 	if err != nil {
-		log.Errorf("error getting S3 object (bucket: %s)(key: %s)(range: %s), err: %v", bucket, key, byteRange, err)
+		return "", fmt.Errorf("error creating S3 multipart upload (bucket: %s)(key: %s), err: %w", bucket, key, c.explain(err))
 	}
-	return output
+	return *output.UploadId, nil
+}
+
+// UploadPart uploads body as part number part (from 1) of a multipart
+// upload, returning the part's ETag. Every part but the last must be at
+// least 5 MiB.
+func (c *Client) UploadPart(ctx context.Context, bucket, key, uploadID string, part int64, body io.ReadSeeker) (string, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return "", err
+	}
+	input := &s3.UploadPartInput{
+		Bucket:     &bucket,
+		Key:        &key,
+		UploadId:   &uploadID,
+		PartNumber: &part,
+		Body:       body,
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	output, err := c.s3.UploadPartWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error uploading S3 part (bucket: %s)(key: %s)(part: %d), err: %w", bucket, key, part, c.explain(err))
+	}
+	return awssdk.StringValue(output.ETag), nil
+}
+
+// UploadPartCopy makes bytes [start, end] (inclusive) of the given version
+// of another object part number part of a multipart upload, copying them
+// within S3, and returns the part's ETag. If etag is not empty, the copy
+// fails unless the source object still has that ETag.
+func (c *Client) UploadPartCopy(ctx context.Context, bucket, key, uploadID string, part int64, srcBucket, srcKey, srcVersion, etag string, start, end int64) (string, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return "", err
+	}
+	source := srcBucket + "/" + url.PathEscape(srcKey)
+	if srcVersion != "" {
+		source += "?versionId=" + url.QueryEscape(srcVersion)
+	}
+	input := &s3.UploadPartCopyInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		PartNumber:      &part,
+		CopySource:      &source,
+		CopySourceRange: awssdk.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	}
+	if etag != "" {
+		input.CopySourceIfMatch = &etag
+	}
+	input.RequestPayer, input.SSECustomerAlgorithm, input.SSECustomerKey = c.objectHeaders()
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey = input.SSECustomerAlgorithm, input.SSECustomerKey
+	output, err := c.s3.UploadPartCopyWithContext(ctx, input)
+	if err != nil {
+		var rf awserr.RequestFailure
+		if etag != "" && errors.As(err, &rf) && rf.StatusCode() == 412 {
+			err = &causeError{err, ErrSourceChanged}
+		} else {
+			err = c.explain(err)
+		}
+		return "", fmt.Errorf("error copying S3 part (bucket: %s)(key: %s)(part: %d)(source: %s)(range: %d-%d), err: %w", bucket, key, part, source, start, end, err)
+	}
+	if output.CopyPartResult == nil {
+		return "", fmt.Errorf("error copying S3 part (bucket: %s)(key: %s)(part: %d), err: no ETag in response", bucket, key, part)
+	}
+	return awssdk.StringValue(output.CopyPartResult.ETag), nil
+}
+
+// CompleteMultipartUpload assembles the object from the parts uploaded,
+// whose ETags are given in order. The object replaces any with its key
+// atomically.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, etags []string) (*s3.CompleteMultipartUploadOutput, error) {
+	if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+		return nil, err
+	}
+	parts := make([]*s3.CompletedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = &s3.CompletedPart{ETag: awssdk.String(etag), PartNumber: awssdk.Int64(int64(i + 1))}
+	}
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}
+	input.RequestPayer, _, _ = c.objectHeaders()
+	output, err := c.s3.CompleteMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error completing S3 multipart upload (bucket: %s)(key: %s), err: %w", bucket, key, c.explain(err))
+	}
+	return output, nil
+}
+
+// AbortMultipartUpload abandons a multipart upload, deleting the parts
+// uploaded so far.
+func (c *Client) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	input := &s3.AbortMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	}
+	input.RequestPayer, _, _ = c.objectHeaders()
+	if _, err := c.s3.AbortMultipartUploadWithContext(ctx, input); err != nil {
+		return fmt.Errorf("error aborting S3 multipart upload (bucket: %s)(key: %s), err: %w", bucket, key, c.explain(err))
+	}
+	return nil
+}
+
+// ListObjects returns the objects in a bucket whose keys start with
+// prefix, making as many requests as it takes.
+func (c *Client) ListObjects(ctx context.Context, bucket, prefix string) ([]*s3.Object, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	}
+	input.RequestPayer, _, _ = c.objectHeaders()
+	var objects []*s3.Object
+	for {
+		if err := getDefaultLimiter().waitRequest(ctx); err != nil {
+			return nil, err
+		}
+		output, err := c.s3.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing S3 objects (bucket: %s)(prefix: %s), err: %w", bucket, prefix, c.explain(err))
+		}
+		objects = append(objects, output.Contents...)
+		if output.IsTruncated == nil || !*output.IsTruncated || output.NextContinuationToken == nil {
+			return objects, nil
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+// versionID returns the VersionId to request, nil for the current version.
+func versionID(version string) *string {
+	if version == "" {
+		return nil
+	}
+	return &version
+}
+
+// optional returns a pointer to s, or nil if it is empty, for request
+// fields to leave unset.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// versionTag describes a version in error messages, if one is given.
+func versionTag(version string) string {
+	if version == "" {
+		return ""
+	}
+	return fmt.Sprintf("(version: %s)", version)
+}
+
+// objectHeaders returns the request payer and SSE-C settings to send with
+// requests for objects, which are nil when not configured.
+func (c *Client) objectHeaders() (payer, algorithm, key *string) {
+	if c.requestPayer {
+		payer = awssdk.String(s3.RequestPayerRequester)
+	}
+	if len(c.sseKey) > 0 {
+		algorithm, key = awssdk.String(sseAlgorithm), awssdk.String(string(c.sseKey))
+	}
+	return payer, algorithm, key
+}
+
+// explain adds the likely cause to errors that S3 returns when a request
+// lacks the request payer or SSE-C settings, going by their error codes.
+// S3 denies access to requester pays buckets with the code it uses for any
+// other denial, so that cause is only a possibility.
+func (c *Client) explain(err error) error {
+	var rf awserr.RequestFailure
+	if !errors.As(err, &rf) {
+		return err
+	}
+	switch {
+	case !c.requestPayer && rf.StatusCode() == 403 && (rf.Code() == "AccessDenied" || rf.Code() == "Forbidden"):
+		// Forbidden is the code given to HEAD responses, which have no body.
+		return &causeError{err, ErrRequesterPays}
+	case len(c.sseKey) == 0 && rf.StatusCode() == 400 && rf.Code() == "InvalidRequest" && strings.Contains(rf.Message(), "Server Side Encryption"):
+		return &causeError{err, ErrSSECustomerKey}
+	}
+	return err
+}
+
+// explainHead is explain for the errors of HEAD requests. Their responses
+// have no body to say why a request was bad, so the object is asked for
+// again with a GET of its first byte, and the cause of that error given.
+func (c *Client) explainHead(ctx context.Context, head *s3.HeadObjectInput, err error) error {
+	var rf awserr.RequestFailure
+	if len(c.sseKey) > 0 || !errors.As(err, &rf) || rf.StatusCode() != 400 {
+		return c.explain(err)
+	}
+	if getDefaultLimiter().waitRequest(ctx) != nil {
+		return err
+	}
+	input := &s3.GetObjectInput{
+		Bucket:       head.Bucket,
+		Key:          head.Key,
+		VersionId:    head.VersionId,
+		Range:        awssdk.String("bytes=0-0"),
+		RequestPayer: head.RequestPayer,
+	}
+	output, getErr := c.s3.GetObjectWithContext(ctx, input)
+	if getErr != nil {
+		if errors.Is(c.explain(getErr), ErrSSECustomerKey) {
+			return &causeError{err, ErrSSECustomerKey}
+		}
+		return err
+	}
+	output.Body.Close()
+	return err
+}
+
+// IsNotFound reports whether err is S3's response to a request for an
+// object that doesn't exist.
+func IsNotFound(err error) bool {
+	var rf awserr.RequestFailure
+	return errors.As(err, &rf) && rf.StatusCode() == 404
+}
+
+// IsAccessDenied reports whether err is S3's refusal of a request, which
+// is also its response to a request for an object that doesn't exist from
+// a caller without s3:ListBucket.
+func IsAccessDenied(err error) bool {
+	var rf awserr.RequestFailure
+	return errors.As(err, &rf) && rf.StatusCode() == 403 && (rf.Code() == "AccessDenied" || rf.Code() == "Forbidden")
+}
+
+// causeError is an error from S3 with a likely cause, which errors.Is
+// matches but which is left out of its message, as it may be wrong.
+type causeError struct {
+	err   error
+	cause error
+}
+
+func (e *causeError) Error() string { return e.err.Error() }
+func (e *causeError) Unwrap() error { return e.err }
+func (e *causeError) Is(target error) bool {
+	return target == e.cause
 }
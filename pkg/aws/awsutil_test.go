@@ -0,0 +1,85 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const sseMessage = "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object."
+
+// failingS3 fails HEAD and GET requests with the given errors.
+type failingS3 struct {
+	s3iface.S3API
+	head, get error
+}
+
+func (f *failingS3) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	return nil, f.head
+}
+
+func (f *failingS3) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	return nil, f.get
+}
+
+func s3Error(status int, code, message string) error {
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), status, "request-id")
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		requestPayer bool
+		want         error
+	}{
+		{"access denied", s3Error(403, "AccessDenied", "Access Denied"), false, ErrRequesterPays},
+		{"HEAD access denied", s3Error(403, "Forbidden", "Forbidden"), false, ErrRequesterPays},
+		{"access denied to payer", s3Error(403, "AccessDenied", "Access Denied"), true, nil},
+		{"unknown access key", s3Error(403, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."), false, nil},
+		{"SSE-C object", s3Error(400, "InvalidRequest", sseMessage), false, ErrSSECustomerKey},
+		{"wrong region", s3Error(400, "AuthorizationHeaderMalformed", "The authorization header is malformed; the region 'us-east-1' is wrong; expecting 'eu-west-1'"), false, nil},
+		{"other bad request", s3Error(400, "InvalidArgument", "Invalid argument."), false, nil},
+		{"not an S3 error", errors.New("connection reset"), false, nil},
+	}
+	for _, tt := range tests {
+		c := &Client{requestPayer: tt.requestPayer}
+		got := c.explain(tt.err)
+		for _, cause := range []error{ErrRequesterPays, ErrSSECustomerKey} {
+			if errors.Is(got, cause) != (cause == tt.want) {
+				t.Errorf("%s: errors.Is(explain(err), %q) = %v", tt.name, cause, !(cause == tt.want))
+			}
+		}
+		if got.Error() != tt.err.Error() {
+			t.Errorf("%s: explain changed the message to %q", tt.name, got.Error())
+		}
+	}
+}
+
+func TestExplainHead(t *testing.T) {
+	badRequest := s3Error(400, "BadRequest", "Bad Request")
+	tests := []struct {
+		name string
+		get  error
+		want bool
+	}{
+		{"SSE-C object", s3Error(400, "InvalidRequest", sseMessage), true},
+		{"wrong region", s3Error(400, "AuthorizationHeaderMalformed", "The authorization header is malformed"), false},
+	}
+	for _, tt := range tests {
+		c := NewClientWithS3(&failingS3{head: badRequest, get: tt.get})
+		_, err := c.GetHeadObject(context.Background(), "bucket", "key")
+		if got := errors.Is(err, ErrSSECustomerKey); got != tt.want {
+			t.Errorf("%s: errors.Is(err, ErrSSECustomerKey) = %v; want %v (err: %v)", tt.name, got, tt.want, err)
+		}
+		var rf awserr.RequestFailure
+		if !errors.As(err, &rf) || rf.Code() != "BadRequest" {
+			t.Errorf("%s: err = %v; want the HEAD request's error", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package aws
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// roleExpiryWindow is how long before assumed role credentials expire that
+// they are refreshed, so that requests in flight don't fail.
+const roleExpiryWindow = time.Minute
+
+// Config overrides the settings that sessions otherwise take from the
+// environment and the shared AWS config files, for example to use an
+// S3-compatible store such as MinIO. Empty fields leave those settings as
+// they are.
+type Config struct {
+	Endpoint  string // URL of the S3 endpoint, e.g. https://minio.example.com:9000
+	Region    string
+	Profile   string // profile in the shared config and credentials files
+	PathStyle bool   // address buckets as endpoint/bucket rather than bucket.endpoint
+
+	// CABundle is the path of a PEM file of certificates to trust instead
+	// of the system's. InsecureSkipVerify turns off verification of the
+	// endpoint's certificate altogether.
+	CABundle           string
+	InsecureSkipVerify bool
+
+	// RoleARN is a role to assume with STS, using the credentials found
+	// as usual, for example in another account. Its credentials are
+	// refreshed before they expire. ExternalID and RoleSessionName are
+	// passed to AssumeRole if set, and STSEndpoint replaces the STS
+	// endpoint.
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	STSEndpoint     string
+
+	// RequestPayer accepts the charges for requests to requester pays
+	// buckets. SSECustomerKey is the 256-bit key of objects encrypted with
+	// a customer-provided key (SSE-C). Both are sent with every request
+	// for an object.
+	RequestPayer   bool
+	SSECustomerKey []byte
+}
+
+// validate checks the settings that the SDK would otherwise only reject
+// with the first request.
+func (cfg *Config) validate() error {
+	if n := len(cfg.SSECustomerKey); n != 0 && n != 32 {
+		return fmt.Errorf("SSE-C key must be 256 bits, got %d bits", n*8)
+	}
+	return nil
+}
+
+// NewSession returns an AWS session for S3 configured by the environment
+// and the shared config files, overridden by cfg.
+func NewSession(cfg Config) (*session.Session, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	opts := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Profile:           cfg.Profile,
+	}
+	if cfg.Region != "" {
+		opts.Config.Region = awssdk.String(cfg.Region)
+	}
+	if cfg.CABundle != "" || cfg.InsecureSkipVerify {
+		// Use a transport of our own, as loading a CA bundle modifies the
+		// session's HTTP client, which is otherwise http.DefaultClient.
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if cfg.InsecureSkipVerify {
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		opts.Config.HTTPClient = &http.Client{Transport: t}
+	}
+	if cfg.CABundle != "" {
+		f, err := os.Open(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle (name: %s), err: %w", cfg.CABundle, err)
+		}
+		defer f.Close()
+		opts.CustomCABundle = f
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session, err: %w", err)
+	}
+
+	// The endpoint settings are for S3 alone, so they are left out of the
+	// session used for STS.
+	s3Config := &awssdk.Config{}
+	if cfg.Endpoint != "" {
+		s3Config.Endpoint = awssdk.String(cfg.Endpoint)
+	}
+	if cfg.PathStyle {
+		s3Config.S3ForcePathStyle = awssdk.Bool(true)
+	}
+	if cfg.RoleARN != "" {
+		stsConfig := &awssdk.Config{}
+		if cfg.STSEndpoint != "" {
+			stsConfig.Endpoint = awssdk.String(cfg.STSEndpoint)
+		}
+		s3Config.Credentials = stscreds.NewCredentialsWithClient(sts.New(sess, stsConfig), cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if cfg.ExternalID != "" {
+				p.ExternalID = awssdk.String(cfg.ExternalID)
+			}
+			if cfg.RoleSessionName != "" {
+				p.RoleSessionName = cfg.RoleSessionName
+			} else {
+				p.RoleSessionName = fmt.Sprintf("zipspy-%d", time.Now().Unix())
+			}
+			p.ExpiryWindow = roleExpiryWindow
+		})
+	}
+	return sess.Copy(s3Config), nil
+}
+
+// NewClientWithConfig creates a new AWS client configured by the
+// environment, overridden by cfg.
+func NewClientWithConfig(cfg Config) (*Client, error) {
+	sess, err := NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClientWithS3(s3.New(sess))
+	c.requestPayer, c.sseKey = cfg.RequestPayer, cfg.SSECustomerKey
+	return c, nil
+}
+
+var defaultSession struct {
+	sync.Mutex
+	sess *session.Session
+	cfg  Config
+}
+
+// SetDefaultConfig makes NewClient create clients configured by cfg, all
+// sharing one session.
+func SetDefaultConfig(cfg Config) error {
+	sess, err := NewSession(cfg)
+	if err != nil {
+		return err
+	}
+	defaultSession.Lock()
+	defer defaultSession.Unlock()
+	defaultSession.sess, defaultSession.cfg = sess, cfg
+	return nil
+}
+
+func getDefaultSession() (*session.Session, Config) {
+	defaultSession.Lock()
+	defer defaultSession.Unlock()
+	return defaultSession.sess, defaultSession.cfg
+}
@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxLimitedRead caps the size of a single read through a rate limited
+// body, so that a slow rate is spread out instead of arriving in bursts.
+const maxLimitedRead = 32 * 1024
+
+// Limiter limits the rate of S3 requests and the bandwidth of the object
+// bodies they return. A single Limiter may be shared by any number of
+// clients and goroutines; the limits apply to all of them together.
+type Limiter struct {
+	bytesPerSecond    int64
+	requestsPerSecond float64
+
+	mu          sync.Mutex
+	nextRequest time.Time // earliest time the next request may be sent
+	nextByte    time.Time // time by which the bytes read so far are paid for
+}
+
+// NewLimiter returns a Limiter allowing bytesPerSecond bytes of object data
+// and requestsPerSecond requests. A limit of zero means no limit.
+func NewLimiter(bytesPerSecond int64, requestsPerSecond float64) *Limiter {
+	return &Limiter{bytesPerSecond: bytesPerSecond, requestsPerSecond: requestsPerSecond}
+}
+
+var defaultLimiter struct {
+	sync.Mutex
+	l *Limiter
+}
+
+// SetDefaultLimiter sets the Limiter used by every Client, including ones
+// already created. A nil Limiter removes the limits.
+func SetDefaultLimiter(l *Limiter) {
+	defaultLimiter.Lock()
+	defer defaultLimiter.Unlock()
+	defaultLimiter.l = l
+}
+
+func getDefaultLimiter() *Limiter {
+	defaultLimiter.Lock()
+	defer defaultLimiter.Unlock()
+	return defaultLimiter.l
+}
+
+// waitRequest waits until another request may be sent.
+func (l *Limiter) waitRequest(ctx context.Context) error {
+	if l == nil || l.requestsPerSecond <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / l.requestsPerSecond)
+	l.mu.Lock()
+	now := time.Now()
+	if l.nextRequest.Before(now) {
+		l.nextRequest = now
+	}
+	at := l.nextRequest
+	l.nextRequest = at.Add(interval)
+	l.mu.Unlock()
+	return sleepUntil(ctx, at)
+}
+
+// waitBytes waits until n more bytes may have been read.
+func (l *Limiter) waitBytes(ctx context.Context, n int) error {
+	if l == nil || l.bytesPerSecond <= 0 || n <= 0 {
+		return nil
+	}
+	d := time.Duration(float64(n) / float64(l.bytesPerSecond) * float64(time.Second))
+	l.mu.Lock()
+	now := time.Now()
+	if l.nextByte.Before(now) {
+		l.nextByte = now
+	}
+	l.nextByte = l.nextByte.Add(d)
+	at := l.nextByte
+	l.mu.Unlock()
+	return sleepUntil(ctx, at)
+}
+
+// body limits the bandwidth of an object body.
+func (l *Limiter) body(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if l == nil || l.bytesPerSecond <= 0 {
+		return rc
+	}
+	return &limitedBody{ReadCloser: rc, ctx: ctx, l: l}
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	ctx context.Context
+	l   *Limiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > maxLimitedRead {
+		p = p[:maxLimitedRead]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if werr := b.l.waitBytes(b.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
@@ -0,0 +1,211 @@
+// Package flateindex builds and uses checkpoint indexes for random access
+// into Deflate streams, in the style of zlib's zran example.
+//
+// Deflate data can normally only be decompressed from the beginning. An
+// Index records, every Span bytes of uncompressed output, the position of a
+// block boundary in the compressed stream together with the 32KiB of output
+// preceding it. Decompression can then resume at the checkpoint nearest to
+// the wanted offset, reading only the compressed bytes from there onwards.
+package flateindex
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// DefaultSpan is the default distance between checkpoints, in bytes of
+// uncompressed data
+const DefaultSpan = 16 << 20
+
+// magic identifies a serialized Index
+const magic = "ZSPYIDX\x01"
+
+// ErrFormat indicates a malformed serialized Index
+var ErrFormat = errors.New("flateindex: not a valid index")
+
+// Checkpoint is a block boundary in a Deflate stream from which
+// decompression can resume.
+type Checkpoint struct {
+	Out    int64  // offset in the uncompressed data
+	In     int64  // offset of the byte holding the block header in the compressed data
+	Bits   uint8  // number of bits of byte In that belong to the previous block
+	Window []byte // up to 32KiB of uncompressed data preceding Out
+}
+
+// Index is a set of checkpoints into a single Deflate stream.
+type Index struct {
+	Span             int64
+	CompressedSize   int64
+	UncompressedSize int64
+	CRC32            uint32 // of the uncompressed data, identifies the stream
+	Checkpoints      []Checkpoint
+}
+
+// Build decompresses the Deflate stream read from r and returns an index
+// with a checkpoint at the start and then roughly every span bytes of
+// uncompressed data.
+func Build(r io.Reader, span int64) (*Index, error) {
+	if span <= 0 {
+		span = DefaultSpan
+	}
+	f, err := newInflater(r, 0, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{Span: span}
+	last := int64(-1)
+	f.onBlock = func(bit, out int64) {
+		if last >= 0 && out-last < span {
+			return
+		}
+		idx.Checkpoints = append(idx.Checkpoints, Checkpoint{
+			Out:    out,
+			In:     bit / 8,
+			Bits:   uint8(bit % 8),
+			Window: append([]byte(nil), f.window()...),
+		})
+		last = out
+	}
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, f)
+	if err != nil {
+		return nil, err
+	}
+	idx.UncompressedSize = n
+	idx.CompressedSize = (f.br.pos() + 7) / 8
+	idx.CRC32 = crc.Sum32()
+	return idx, nil
+}
+
+// Locate returns the checkpoint to resume from to read the n uncompressed
+// bytes at off, and the range [start, end) of compressed bytes needed to
+// do so.
+func (idx *Index) Locate(off, n int64) (cp *Checkpoint, start, end int64) {
+	cps := idx.Checkpoints
+	i := sort.Search(len(cps), func(i int) bool { return cps[i].Out > off }) - 1
+	if i < 0 {
+		i = 0
+	}
+	cp = &cps[i]
+	end = idx.CompressedSize
+	j := sort.Search(len(cps), func(j int) bool { return cps[j].Out >= off+n })
+	if j < len(cps) && cps[j].In+1 < end {
+		end = cps[j].In + 1 // the block before it may end part way through this byte
+	}
+	return cp, cp.In, end
+}
+
+// NewReader returns a reader over the uncompressed data from cp.Out
+// onwards. r must supply the compressed data starting at byte cp.In.
+func NewReader(r io.Reader, cp *Checkpoint) (io.Reader, error) {
+	return newInflater(r, cp.Bits, cp.Window, cp.Out)
+}
+
+// WriteTo writes the index in a compact binary form, which ReadIndex
+// reads back.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	if _, err := io.WriteString(cw, magic); err != nil {
+		return cw.n, err
+	}
+	fw, err := flate.NewWriter(cw, flate.DefaultCompression)
+	if err != nil {
+		return cw.n, err
+	}
+	bw := bufio.NewWriter(fw)
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	putUvarint(uint64(idx.Span))
+	putUvarint(uint64(idx.CompressedSize))
+	putUvarint(uint64(idx.UncompressedSize))
+	putUvarint(uint64(idx.CRC32))
+	putUvarint(uint64(len(idx.Checkpoints)))
+	for _, cp := range idx.Checkpoints {
+		putUvarint(uint64(cp.Out))
+		putUvarint(uint64(cp.In))
+		bw.WriteByte(cp.Bits)
+		putUvarint(uint64(len(cp.Window)))
+		bw.Write(cp.Window)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	err = fw.Close()
+	return cw.n, err
+}
+
+// ReadIndex reads an index written by WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, head); err != nil || string(head) != magic {
+		return nil, ErrFormat
+	}
+	fr := flate.NewReader(r)
+	defer fr.Close()
+	br := bufio.NewReader(fr)
+	var err error
+	uvarint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(br)
+		if v > 1<<62 {
+			err = ErrFormat
+		}
+		return int64(v)
+	}
+	idx := &Index{
+		Span:             uvarint(),
+		CompressedSize:   uvarint(),
+		UncompressedSize: uvarint(),
+		CRC32:            uint32(uvarint()),
+	}
+	count := uvarint()
+	if err == nil && count == 0 {
+		err = ErrFormat
+	}
+	for i := int64(0); err == nil && i < count; i++ {
+		cp := Checkpoint{Out: uvarint(), In: uvarint()}
+		if err != nil {
+			break
+		}
+		if cp.Bits, err = br.ReadByte(); err != nil || cp.Bits > 7 {
+			return nil, ErrFormat
+		}
+		n := uvarint()
+		if err != nil || n > windowSize {
+			return nil, ErrFormat
+		}
+		cp.Window = make([]byte, n)
+		if _, err = io.ReadFull(br, cp.Window); err != nil {
+			break
+		}
+		idx.Checkpoints = append(idx.Checkpoints, cp)
+	}
+	if err != nil {
+		return nil, ErrFormat
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, ErrFormat
+	}
+	return idx, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
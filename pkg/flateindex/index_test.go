@@ -0,0 +1,127 @@
+package flateindex
+
+import (
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// testData returns n bytes of text, with runs of random bytes that the
+// compressor stores rather than compresses.
+func testData(rnd *rand.Rand, n int) []byte {
+	words := []string{"alpha ", "beta ", "gamma\n", "delta ", "0123456789 ", "log line "}
+	var data []byte
+	for len(data) < n {
+		if rnd.Intn(50) == 0 {
+			b := make([]byte, rnd.Intn(40000))
+			rnd.Read(b)
+			data = append(data, b...)
+		}
+		data = append(data, words[rnd.Intn(len(words))]...)
+	}
+	return data[:n]
+}
+
+func compress(t *testing.T, data []byte, level int) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIndex(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	data := testData(rnd, 1<<20)
+	levels := []int{flate.NoCompression, flate.BestSpeed, flate.DefaultCompression, flate.BestCompression, flate.HuffmanOnly}
+	for _, level := range levels {
+		comp := compress(t, data, level)
+		idx, err := Build(bytes.NewReader(comp), 64<<10)
+		if err != nil {
+			t.Fatalf("level %d: Build: %v", level, err)
+		}
+		if idx.UncompressedSize != int64(len(data)) || idx.CompressedSize != int64(len(comp)) {
+			t.Fatalf("level %d: sizes = %d, %d; want %d, %d", level, idx.UncompressedSize, idx.CompressedSize, len(data), len(comp))
+		}
+		if idx.CRC32 != crc32.ChecksumIEEE(data) {
+			t.Errorf("level %d: CRC32 = %08x; want %08x", level, idx.CRC32, crc32.ChecksumIEEE(data))
+		}
+		if len(idx.Checkpoints) < 2 || idx.Checkpoints[0].Out != 0 {
+			t.Fatalf("level %d: checkpoints = %d, first at %d", level, len(idx.Checkpoints), idx.Checkpoints[0].Out)
+		}
+
+		for i := 0; i < 100; i++ {
+			off := rnd.Int63n(int64(len(data)))
+			n := rnd.Int63n(100000)
+			if off+n > int64(len(data)) {
+				n = int64(len(data)) - off
+			}
+			cp, start, end := idx.Locate(off, n)
+			if cp.Out > off {
+				t.Fatalf("level %d: Locate(%d, %d) = checkpoint at %d", level, off, n, cp.Out)
+			}
+			r, err := NewReader(bytes.NewReader(comp[start:end]), cp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.CopyN(ioutil.Discard, r, off-cp.Out); err != nil {
+				t.Fatalf("level %d: skipping to %d from %d: %v", level, off, cp.Out, err)
+			}
+			got, err := ioutil.ReadAll(io.LimitReader(r, n))
+			if err != nil || !bytes.Equal(got, data[off:off+n]) {
+				t.Fatalf("level %d: reading %d bytes at %d from checkpoint %d/%d: got %d bytes, err %v", level, n, off, cp.Out, cp.Bits, len(got), err)
+			}
+		}
+	}
+}
+
+func TestWriteToReadIndex(t *testing.T) {
+	data := testData(rand.New(rand.NewSource(2)), 512<<10)
+	idx, err := Build(bytes.NewReader(compress(t, data, flate.DefaultCompression)), 32<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadIndex(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Span != idx.Span || got.CompressedSize != idx.CompressedSize || got.UncompressedSize != idx.UncompressedSize || got.CRC32 != idx.CRC32 || len(got.Checkpoints) != len(idx.Checkpoints) {
+		t.Fatalf("ReadIndex = %d/%d/%d/%08x with %d checkpoints; want %d/%d/%d/%08x with %d", got.Span, got.CompressedSize, got.UncompressedSize, got.CRC32, len(got.Checkpoints), idx.Span, idx.CompressedSize, idx.UncompressedSize, idx.CRC32, len(idx.Checkpoints))
+	}
+	for i, cp := range idx.Checkpoints {
+		g := got.Checkpoints[i]
+		if g.Out != cp.Out || g.In != cp.In || g.Bits != cp.Bits || !bytes.Equal(g.Window, cp.Window) {
+			t.Errorf("checkpoint %d = %d/%d/%d; want %d/%d/%d", i, g.Out, g.In, g.Bits, cp.Out, cp.In, cp.Bits)
+		}
+	}
+
+	b := buf.Bytes()
+	for _, bad := range [][]byte{nil, b[:len(magic)], b[:len(b)-4], append([]byte("ZSPYIDX\x02"), b[len(magic):]...)} {
+		if _, err := ReadIndex(bytes.NewReader(bad)); err != ErrFormat {
+			t.Errorf("ReadIndex of %d bytes: err = %v; want ErrFormat", len(bad), err)
+		}
+	}
+}
+
+func TestBuildCorrupt(t *testing.T) {
+	comp := compress(t, testData(rand.New(rand.NewSource(3)), 64<<10), flate.DefaultCompression)
+	if _, err := Build(bytes.NewReader(comp[:len(comp)/2]), 0); err == nil {
+		t.Error("Build of a truncated stream succeeded")
+	}
+	if _, err := Build(bytes.NewReader([]byte{0xff, 0xff, 0xff}), 0); err == nil {
+		t.Error("Build of an invalid stream succeeded")
+	}
+}
@@ -0,0 +1,408 @@
+package flateindex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrCorrupt indicates malformed Deflate data
+var ErrCorrupt = errors.New("flateindex: corrupt deflate data")
+
+const (
+	windowSize  = 1 << 15 // maximum Deflate back-reference distance
+	maxBuffered = 1 << 15 // decoded bytes to buffer per call to step
+	maxCodeLen  = 15
+)
+
+var (
+	lengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]uint32{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// order in which code length code lengths are stored
+	codeLenOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLit, fixedDist *huffman
+)
+
+func init() {
+	var lengths [288]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	fixedLit, _ = newHuffman(lengths[:])
+	var dist [30]uint8
+	for i := range dist {
+		dist[i] = 5
+	}
+	fixedDist, _ = newHuffman(dist[:])
+}
+
+// bitReader reads a Deflate bit stream, least significant bit first, and
+// keeps track of its position.
+type bitReader struct {
+	r    io.ByteReader
+	bits uint64 // buffered bits, next bit in the lowest position
+	n    uint   // number of buffered bits
+	read int64  // bytes read from r
+}
+
+// pos returns the bit position of the next unread bit.
+func (b *bitReader) pos() int64 {
+	return b.read*8 - int64(b.n)
+}
+
+// fill buffers at least n bits, or as many as remain before an error.
+func (b *bitReader) fill(n uint) error {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		b.bits |= uint64(c) << b.n
+		b.n += 8
+		b.read++
+	}
+	return nil
+}
+
+func (b *bitReader) get(n uint) (uint32, error) {
+	if err := b.fill(n); err != nil {
+		return 0, err
+	}
+	v := uint32(b.bits & (1<<n - 1))
+	b.bits >>= n
+	b.n -= n
+	return v, nil
+}
+
+// align discards bits up to the next byte boundary.
+func (b *bitReader) align() {
+	b.bits >>= b.n % 8
+	b.n -= b.n % 8
+}
+
+// huffman is a canonical Huffman code, decoded with a single lookup table
+// indexed by the next maxLen bits of input.
+type huffman struct {
+	table  []uint32 // symbol<<4 | code length, 0 for unused codes
+	maxLen uint
+}
+
+func newHuffman(lengths []uint8) (*huffman, error) {
+	var count [maxCodeLen + 1]int
+	var maxLen uint
+	for _, l := range lengths {
+		count[l]++
+		if uint(l) > maxLen {
+			maxLen = uint(l)
+		}
+	}
+	count[0] = 0
+	left := 1
+	for l := 1; l <= maxCodeLen; l++ {
+		left <<= 1
+		left -= count[l]
+		if left < 0 {
+			return nil, ErrCorrupt // over-subscribed
+		}
+	}
+	var next [maxCodeLen + 1]int
+	code := 0
+	for l := 1; l <= maxCodeLen; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	h := &huffman{table: make([]uint32, 1<<maxLen), maxLen: maxLen}
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		rev := 0 // codes are stored most significant bit first
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | c>>i&1
+		}
+		for i := rev; i < len(h.table); i += 1 << l {
+			h.table[i] = uint32(sym)<<4 | uint32(l)
+		}
+	}
+	return h, nil
+}
+
+// inflater decompresses a raw Deflate stream. Unlike compress/flate it can
+// start at any block boundary, including one that is not byte aligned, given
+// the window preceding it, and it can report where each block starts.
+type inflater struct {
+	br    bitReader
+	hist  []byte // at least the last windowSize bytes of output, then unread output
+	rpos  int    // offset of unread output in hist
+	out   int64  // uncompressed offset of the end of hist
+	err   error  // sticky error from step
+	final bool   // the final block has started
+
+	inBlock   bool
+	stored    int // bytes left in the current stored block
+	lit, dist *huffman
+
+	// onBlock, if set, is called before each block header is read with the
+	// bit position of the header and the uncompressed offset at that point.
+	onBlock func(bit, out int64)
+}
+
+// newInflater returns an inflater reading compressed data from r, which
+// starts at bit offset bits of its first byte, resuming with the given
+// window of preceding output at uncompressed offset out.
+func newInflater(r io.Reader, bits uint8, window []byte, out int64) (*inflater, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	f := &inflater{
+		br:   bitReader{r: br},
+		hist: append(make([]byte, 0, 2*windowSize+maxBuffered), window...),
+		out:  out,
+	}
+	f.rpos = len(f.hist)
+	if _, err := f.br.get(uint(bits)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *inflater) Read(p []byte) (int, error) {
+	for f.rpos == len(f.hist) {
+		if f.err != nil {
+			return 0, f.err
+		}
+		if f.rpos > 2*windowSize {
+			n := copy(f.hist, f.hist[f.rpos-windowSize:])
+			f.hist = f.hist[:n]
+			f.rpos = windowSize
+		}
+		f.err = f.step()
+	}
+	n := copy(p, f.hist[f.rpos:])
+	f.rpos += n
+	return n, nil
+}
+
+// window returns up to windowSize bytes of output preceding the current
+// position.
+func (f *inflater) window() []byte {
+	w := f.hist
+	if len(w) > windowSize {
+		w = w[len(w)-windowSize:]
+	}
+	return w
+}
+
+// step decodes until maxBuffered bytes are waiting to be read or the stream
+// ends. Output decoded before an error is kept.
+func (f *inflater) step() error {
+	for len(f.hist)-f.rpos < maxBuffered {
+		if !f.inBlock {
+			if f.final {
+				return io.EOF
+			}
+			if f.onBlock != nil {
+				f.onBlock(f.br.pos(), f.out)
+			}
+			if err := f.readHeader(); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.lit == nil {
+			if f.stored == 0 {
+				f.inBlock = false
+				continue
+			}
+			c, err := f.br.get(8)
+			if err != nil {
+				return err
+			}
+			f.hist = append(f.hist, byte(c))
+			f.out++
+			f.stored--
+			continue
+		}
+		sym, err := f.decode(f.lit)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sym < 256:
+			f.hist = append(f.hist, byte(sym))
+			f.out++
+		case sym == 256:
+			f.inBlock = false
+		default:
+			sym -= 257
+			if sym >= len(lengthBase) {
+				return ErrCorrupt
+			}
+			extra, err := f.br.get(uint(lengthExtra[sym]))
+			if err != nil {
+				return err
+			}
+			length := int(lengthBase[sym]) + int(extra)
+			dsym, err := f.decode(f.dist)
+			if err != nil {
+				return err
+			}
+			if dsym >= len(distBase) {
+				return ErrCorrupt
+			}
+			if extra, err = f.br.get(uint(distExtra[dsym])); err != nil {
+				return err
+			}
+			dist := int(distBase[dsym]) + int(extra)
+			if dist > len(f.hist) {
+				return ErrCorrupt
+			}
+			f.out += int64(length)
+			for i := len(f.hist) - dist; length > 0; length-- {
+				f.hist = append(f.hist, f.hist[i])
+				i++
+			}
+		}
+	}
+	return nil
+}
+
+func (f *inflater) decode(h *huffman) (int, error) {
+	if h.maxLen == 0 {
+		return 0, ErrCorrupt
+	}
+	err := f.br.fill(h.maxLen)
+	e := h.table[f.br.bits&(1<<h.maxLen-1)]
+	l := uint(e & 15)
+	if l == 0 || l > f.br.n {
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrCorrupt
+	}
+	f.br.bits >>= l
+	f.br.n -= l
+	return int(e >> 4), nil
+}
+
+func (f *inflater) readHeader() error {
+	hdr, err := f.br.get(3)
+	if err != nil {
+		return err
+	}
+	f.final = hdr&1 == 1
+	f.inBlock = true
+	switch hdr >> 1 {
+	case 0:
+		f.lit, f.dist = nil, nil
+		f.br.align()
+		v, err := f.br.get(32)
+		if err != nil {
+			return err
+		}
+		if uint16(v) != ^uint16(v>>16) {
+			return ErrCorrupt
+		}
+		f.stored = int(uint16(v))
+	case 1:
+		f.lit, f.dist = fixedLit, fixedDist
+	case 2:
+		return f.readDynamic()
+	default:
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func (f *inflater) readDynamic() error {
+	v, err := f.br.get(14)
+	if err != nil {
+		return err
+	}
+	nlen := int(v&0x1f) + 257
+	ndist := int(v>>5&0x1f) + 1
+	ncode := int(v>>10) + 4
+	if nlen > 286 || ndist > 30 {
+		return ErrCorrupt
+	}
+	var codeLens [19]uint8
+	for i := 0; i < ncode; i++ {
+		l, err := f.br.get(3)
+		if err != nil {
+			return err
+		}
+		codeLens[codeLenOrder[i]] = uint8(l)
+	}
+	lencode, err := newHuffman(codeLens[:])
+	if err != nil {
+		return err
+	}
+	lengths := make([]uint8, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		sym, err := f.decode(lencode)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var rep uint32
+		var val uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return ErrCorrupt
+			}
+			val = lengths[i-1]
+			rep, err = f.br.get(2)
+			rep += 3
+		case 17:
+			rep, err = f.br.get(3)
+			rep += 3
+		default:
+			rep, err = f.br.get(7)
+			rep += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+int(rep) > len(lengths) {
+			return ErrCorrupt
+		}
+		for ; rep > 0; rep-- {
+			lengths[i] = val
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return ErrCorrupt // no end-of-block code
+	}
+	if f.lit, err = newHuffman(lengths[:nlen]); err != nil {
+		return err
+	}
+	if f.dist, err = newHuffman(lengths[nlen:]); err != nil {
+		return err
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package reader
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"time"
 )
 
 type Reader struct {
@@ -17,7 +19,8 @@ type File struct {
 	Zip          *Reader
 	Zipr         io.ReaderAt
 	Zipsize      int64
-	HeaderOffset int64
+	HeaderOffset int64  // relative to the start of disk DiskNumber
+	DiskNumber   uint32 // disk (segment) of a split archive holding the local header
 }
 
 type FileReader struct {
@@ -35,7 +38,13 @@ func (f *File) Open() (io.ReadCloser, error) {
 		return nil, err
 	}
 	size := int64(f.CompressedSize64)
-	r := io.NewSectionReader(f.Zipr, bodyOffset, size)
+	return f.OpenData(io.NewSectionReader(f.Zipr, bodyOffset, size))
+}
+
+// OpenData is like Open, but decompresses the File's data as it is read
+// from r, which holds just the data rather than the local header and data,
+// so that the File can be read as it is fetched.
+func (f *File) OpenData(r io.Reader) (io.ReadCloser, error) {
 	dcomp := f.Zip.decompressor(f.Method)
 	if dcomp == nil {
 		return nil, ErrAlgorithm
@@ -73,40 +82,83 @@ func ReadDirectoryEnd(r io.ReaderAt, bufSize, totalSize int64) (dir *DirectoryEn
 	if bufSize > totalSize {
 		bufSize = totalSize
 	}
-	buf := make([]byte, int(bufSize))
-	var dEndOffset int64
-	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+	block := make([]byte, int(bufSize))
+	if _, err := r.ReadAt(block, 0); err != nil && err != io.EOF {
 		return nil, err
 	}
-	if p := findEOCDSignatureInBlock(buf); p >= 0 {
-		buf = buf[p:]
-		dEndOffset = totalSize - bufSize + int64(p)
-	} else {
+	p := findEOCDSignatureInBlock(block)
+	if p < 0 {
 		return nil, ErrFormat
 	}
+	dEndOffset := totalSize - bufSize + int64(p)
 
-	b := readBuf(buf[10:]) // skip signature & unncessary fields
+	b := readBuf(block[p+4:]) // skip signature
 	d := &DirectoryEnd{
-		directoryRecords:   uint64(b.uint16()),
-		directorySize:      uint64(b.uint32()),
-		DirectoryOffset:    uint64(b.uint32()),
-		DirectoryEndOffset: uint64(dEndOffset),
-		commentLen:         b.uint16(),
+		DiskNumber:    uint32(b.uint16()),
+		DirectoryDisk: uint32(b.uint16()),
 	}
+	b = b[2:] // skip number of records on this disk
+	d.directoryRecords = uint64(b.uint16())
+	d.directorySize = uint64(b.uint32())
+	d.DirectoryOffset = uint64(b.uint32())
+	d.DirectoryEndOffset = uint64(dEndOffset)
+	d.commentLen = b.uint16()
 
 	l := int(d.commentLen)
 	if l > len(b) {
 		return nil, ErrCommentLength
 	}
+	d.comment = string(b[:l])
+
+	// Values too large for the EOCD record are in a zip64 end of central
+	// directory record, which comes before it and its locator.
+	dirEnd := dEndOffset
+	if d.directoryRecords == 0xffff || d.directorySize == 0xffffffff || d.DirectoryOffset == 0xffffffff {
+		if p64 := p - directory64LocLen - directory64EndLen; p64 >= 0 && readDirectory64End(block[p64:p], d) {
+			dirEnd -= directory64LocLen + directory64EndLen
+		}
+	}
+
+	// Data prepended to the archive (a self-extractor stub, say) shifts
+	// every offset in it; the directory ends where the EOCD record (or
+	// the zip64 one) starts. In a split archive the offsets are relative
+	// to their disks instead, and are checked later.
+	if d.DiskNumber == 0 {
+		d.BaseOffset = dirEnd - int64(d.directorySize) - int64(d.DirectoryOffset)
+		d.DirectoryOffset = uint64(d.BaseOffset + int64(d.DirectoryOffset))
+	}
 
 	// Make sure directoryOffset points to somewhere in our file.
-	if o := int64(d.DirectoryOffset); o < 0 || o >= totalSize {
+	if o := int64(d.DirectoryOffset); d.DirectoryDisk == d.DiskNumber && (o < 0 || o >= totalSize) {
 		return nil, ErrFormat
 	}
 
 	return d, nil
 }
 
+// readDirectory64End reads the zip64 end of central directory record and
+// its locator from b into d, reporting whether they were found; d is left
+// as it was if not. Records with an extensible data sector, which no
+// common tool writes, are not.
+func readDirectory64End(b readBuf, d *DirectoryEnd) bool {
+	if sig := b.uint32(); sig != directory64EndSignature {
+		return false
+	}
+	d64 := *d
+	b = b[12:] // skip the record size and versions
+	d64.DiskNumber = b.uint32()
+	d64.DirectoryDisk = b.uint32()
+	b = b[8:] // skip number of records on this disk
+	d64.directoryRecords = b.uint64()
+	d64.directorySize = b.uint64()
+	d64.DirectoryOffset = b.uint64()
+	if b.uint32() != directory64LocSignature {
+		return false
+	}
+	*d = d64
+	return true
+}
+
 func ReadDirectoryHeader(f *File, r io.Reader) error {
 	var buf [directoryHeaderLen]byte
 	if _, err := io.ReadFull(r, buf[:]); err != nil {
@@ -117,16 +169,24 @@ func ReadDirectoryHeader(f *File, r io.Reader) error {
 		return ErrFormat
 	}
 
-	f.Flags = b.skip(4).uint16()
+	f.CreatorVersion = b.uint16()
+	f.ReaderVersion = b.uint16()
+	f.Flags = b.uint16()
 	f.Method = b.uint16()
-	f.CompressedSize = b.skip(8).uint32()
+	f.ModifiedTime = b.uint16()
+	f.ModifiedDate = b.uint16()
+	f.CRC32 = b.uint32()
+	f.CompressedSize = b.uint32()
 	f.UncompressedSize = b.uint32()
 	f.CompressedSize64 = uint64(f.CompressedSize)
 	f.UncompressedSize64 = uint64(f.UncompressedSize)
 	filenameLen := int(b.uint16())
 	extraLen := int(b.uint16())
 	commentLen := int(b.uint16())
-	f.HeaderOffset = int64(b.skip(8).uint32())
+	f.DiskNumber = uint32(b.uint16())
+	f.InternalAttrs = b.uint16()
+	f.ExternalAttrs = b.uint32()
+	f.HeaderOffset = int64(b.uint32())
 
 	d := make([]byte, filenameLen+extraLen+commentLen)
 	if _, err := io.ReadFull(r, d); err != nil {
@@ -139,6 +199,8 @@ func ReadDirectoryHeader(f *File, r io.Reader) error {
 	needUSize := f.UncompressedSize == ^uint32(0)
 	needCSize := f.CompressedSize == ^uint32(0)
 	needHeaderOffset := f.HeaderOffset == int64(^uint32(0))
+	needDiskNumber := f.DiskNumber == uint32(^uint16(0))
+	f.Modified = msDosTimeToTime(f.ModifiedDate, f.ModifiedTime)
 
 	for extra := readBuf(f.Extra); len(extra) >= 4; {
 		fieldTag := extra.uint16()
@@ -174,6 +236,18 @@ func ReadDirectoryHeader(f *File, r io.Reader) error {
 				}
 				f.HeaderOffset = int64(fieldBuf.uint64())
 			}
+			if needDiskNumber {
+				needDiskNumber = false
+				if len(fieldBuf) < 4 {
+					return ErrFormat
+				}
+				f.DiskNumber = fieldBuf.uint32()
+			}
+		case extTimeExtraID:
+			if len(fieldBuf) < 5 || fieldBuf.uint8()&1 == 0 {
+				continue
+			}
+			f.Modified = time.Unix(int64(fieldBuf.uint32()), 0)
 		}
 	}
 	return nil
@@ -191,6 +265,12 @@ func findEOCDSignatureInBlock(b []byte) int {
 	return -1
 }
 
+// DataOffset returns the offset of the file's possibly-compressed data,
+// relative to the start of Zipr (the file's local header).
+func (f *File) DataOffset() (int64, error) {
+	return f.findBodyOffset()
+}
+
 // findBodyOffset does the minimum work to verify the file has a header
 // and returns the file body offset.
 func (f *File) findBodyOffset() (int64, error) {
@@ -208,6 +288,76 @@ func (f *File) findBodyOffset() (int64, error) {
 	return int64(fileHeaderLen + filenameLen + extraLen), nil
 }
 
+// CheckLocalHeader compares the file's local header with its central
+// directory header: the signature, name, compression method, CRC-32 and
+// sizes must agree. For files with a data descriptor, the CRC-32 and sizes
+// are in that instead; see CheckDataDescriptor. The errors returned wrap
+// ErrLocalHeader.
+func (f *File) CheckLocalHeader() error {
+	var buf [fileHeaderLen]byte
+	if _, err := f.Zipr.ReadAt(buf[:], 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrLocalHeader, err)
+	}
+	b := readBuf(buf[:])
+	if sig := b.uint32(); sig != fileHeaderSignature {
+		return fmt.Errorf("%w: bad signature %#08x", ErrLocalHeader, sig)
+	}
+	b = b[2:] // skip the version
+	flags := b.uint16()
+	method := b.uint16()
+	b = b[4:] // skip the modification time, which may be rounded differently
+	crc, csize, usize := b.uint32(), b.uint32(), b.uint32()
+	filenameLen := int(b.uint16())
+
+	name := make([]byte, filenameLen)
+	if _, err := f.Zipr.ReadAt(name, fileHeaderLen); err != nil {
+		return fmt.Errorf("%w: %v", ErrLocalHeader, err)
+	}
+	switch {
+	case string(name) != f.Name:
+		return fmt.Errorf("%w: name %q", ErrLocalHeader, name)
+	case method != f.Method:
+		return fmt.Errorf("%w: method %d, directory says %d", ErrLocalHeader, method, f.Method)
+	}
+	if flags&0x8 == 0 {
+		// The values are in the local header. Sizes of ^uint32(0) are
+		// in its zip64 extra field instead, which isn't checked.
+		switch {
+		case crc != f.CRC32:
+			return fmt.Errorf("%w: CRC-32 %08x, directory says %08x", ErrLocalHeader, crc, f.CRC32)
+		case csize != ^uint32(0) && uint64(csize) != f.CompressedSize64:
+			return fmt.Errorf("%w: compressed size %d, directory says %d", ErrLocalHeader, csize, f.CompressedSize64)
+		case usize != ^uint32(0) && uint64(usize) != f.UncompressedSize64:
+			return fmt.Errorf("%w: size %d, directory says %d", ErrLocalHeader, usize, f.UncompressedSize64)
+		}
+	}
+	return nil
+}
+
+// CheckDataDescriptor reads the file's data descriptor from r, which starts
+// where its data ends, and compares its CRC-32 with the central directory
+// header's. Files without a data descriptor pass without reading r. The
+// errors returned wrap ErrLocalHeader.
+func (f *File) CheckDataDescriptor(r io.Reader) error {
+	if f.Flags&0x8 == 0 {
+		return nil
+	}
+	// The descriptor may start with a signature.
+	var desc [8]byte
+	if _, err := io.ReadFull(r, desc[:]); err != nil {
+		return fmt.Errorf("%w: data descriptor: %v", ErrLocalHeader, err)
+	}
+	d := readBuf(desc[:])
+	crc := d.uint32()
+	if crc == dataDescriptorSignature {
+		crc = d.uint32()
+	}
+	if crc != f.CRC32 {
+		return fmt.Errorf("%w: data descriptor CRC-32 %08x, directory says %08x", ErrLocalHeader, crc, f.CRC32)
+	}
+	return nil
+}
+
 // RegisterDecompressor registers or overrides a custom decompressor for a
 // specific method ID. If a decompressor for a given method is not found,
 // Reader will default to looking up the decompressor at the package level.
@@ -0,0 +1,113 @@
+package reader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// testArchive returns an archive of n empty files written by archive/zip,
+// after prefix. archive/zip writes the zip64 records for 65535 files or
+// more.
+func testArchive(t *testing.T, prefix string, n int, comment string) []byte {
+	buf := bytes.NewBufferString(prefix)
+	zw := zip.NewWriter(buf)
+	zw.SetOffset(0) // offsets relative to the archive, as a stub is prepended
+	for i := 0; i < n; i++ {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("f%d", i), Method: zip.Store}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.SetComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadDirectoryEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		n       int
+		comment string
+		zip64   bool
+	}{
+		{name: "plain", n: 3},
+		{name: "comment", n: 3, comment: "built by ci"},
+		{name: "prepended", prefix: "#!/bin/sh\nexec unzip \"$0\"\n", n: 3},
+		{name: "zip64", n: 1 << 16, zip64: true},
+		{name: "zip64 prepended", prefix: "MZ stub", n: 1 << 16, comment: "sfx", zip64: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testArchive(t, tt.prefix, tt.n, tt.comment)
+			if got := bytes.Contains(data, []byte{0x50, 0x4b, 0x06, 0x06}); got != tt.zip64 {
+				t.Fatalf("zip64 record written = %v; want %v", got, tt.zip64)
+			}
+			bufSize := int64(1024)
+			if bufSize > int64(len(data)) {
+				bufSize = int64(len(data))
+			}
+			tail := data[int64(len(data))-bufSize:]
+			d, err := ReadDirectoryEnd(bytes.NewReader(tail), bufSize, int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.directoryRecords != uint64(tt.n) {
+				t.Errorf("directoryRecords = %d; want %d", d.directoryRecords, tt.n)
+			}
+			if d.BaseOffset != int64(len(tt.prefix)) {
+				t.Errorf("BaseOffset = %d; want %d", d.BaseOffset, len(tt.prefix))
+			}
+			if d.Comment() != tt.comment {
+				t.Errorf("Comment() = %q; want %q", d.Comment(), tt.comment)
+			}
+			var f File
+			if err := ReadDirectoryHeader(&f, bytes.NewReader(data[d.DirectoryOffset:])); err != nil {
+				t.Fatalf("reading directory header at %d: %v", d.DirectoryOffset, err)
+			}
+			if f.Name != "f0" {
+				t.Errorf("first entry = %q; want f0", f.Name)
+			}
+		})
+	}
+}
+
+func TestReadDirectoryEndFormat(t *testing.T) {
+	data := testArchive(t, "", 3, "")
+	oversized := append([]byte(nil), data...)
+	oversized[len(data)-directoryEndLen+14] = 0xff // directory size larger than the file
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"no EOCD record", data[:len(data)-directoryEndLen], ErrFormat},
+		{"directory before the file", oversized, ErrFormat},
+	}
+	for _, tt := range tests {
+		n := int64(len(tt.data))
+		if _, err := ReadDirectoryEnd(bytes.NewReader(tt.data), n, n); err != tt.want {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReadDirectory64EndBadLocator(t *testing.T) {
+	// A zip64 record whose fields are all 0xee, not followed by a locator.
+	b := bytes.Repeat([]byte{0xee}, directory64EndLen+directory64LocLen)
+	binary.LittleEndian.PutUint32(b, directory64EndSignature)
+	d := DirectoryEnd{DirectoryOffset: 100, directoryRecords: 3, directorySize: 50}
+	want := d
+	if readDirectory64End(b, &d) {
+		t.Fatal("readDirectory64End found a record without a locator")
+	}
+	if d != want {
+		t.Errorf("directory end = %+v after a failed read; want it unchanged, %+v", d, want)
+	}
+}
@@ -22,6 +22,13 @@ type Compressor func(w io.Writer) (io.WriteCloser, error)
 // one goroutine at a time.
 type Decompressor func(r io.Reader) io.ReadCloser
 
+// nopCloser is the compressing writer of Store, which writes to w as is.
+type nopCloser struct {
+	io.Writer
+}
+
+func (w nopCloser) Close() error { return nil }
+
 var flateWriterPool sync.Pool
 
 func newFlateWriter(w io.Writer) io.WriteCloser {
@@ -104,6 +111,9 @@ var (
 )
 
 func init() {
+	compressors.Store(Store, Compressor(func(w io.Writer) (io.WriteCloser, error) { return &nopCloser{w}, nil }))
+	compressors.Store(Deflate, Compressor(func(w io.Writer) (io.WriteCloser, error) { return newFlateWriter(w), nil }))
+
 	decompressors.Store(Store, Decompressor(ioutil.NopCloser))
 	decompressors.Store(Deflate, Decompressor(newFlateReader))
 }
@@ -124,6 +134,12 @@ func RegisterCompressor(method uint16, comp Compressor) {
 	}
 }
 
+// HasCompressor reports whether a compressor is registered for method, so
+// that NewCompressor can write entries with it.
+func HasCompressor(method uint16) bool {
+	return compressor(method) != nil
+}
+
 func compressor(method uint16) Compressor {
 	ci, ok := compressors.Load(method)
 	if !ok {
@@ -2,6 +2,9 @@ package reader
 
 import (
 	"errors"
+	"io/fs"
+	"path"
+	"time"
 )
 
 var (
@@ -11,21 +14,50 @@ var (
 	ErrCommentLength = errors.New("zip: invalid comment length")
 	// ErrAlgorithm indicates an invalid/unsupported compression algorithm
 	ErrAlgorithm = errors.New("zip: unsupported compression algorithm")
+	// ErrChecksum indicates a file whose contents don't match its CRC-32
+	ErrChecksum = errors.New("zip: checksum error")
+	// ErrLocalHeader indicates a local file header (or data descriptor)
+	// that doesn't match the file's central directory header
+	ErrLocalHeader = errors.New("zip: local header does not match central directory")
 )
 
 const (
 	directoryEndLen    = 22
+	directory64LocLen  = 20
+	directory64EndLen  = 56 // + extensible data
 	directoryHeaderLen = 46
 	fileHeaderLen      = 30 // + filename + extra
 
 	dataDescriptorSignature  = 0x08074b50
+	directory64EndSignature  = 0x06064b50
+	directory64LocSignature  = 0x07064b50
 	directoryEndSignature    = 0x06054b50
 	directoryHeaderSignature = 0x02014b50
 	fileHeaderSignature      = 0x04034b50
 
-	zip64ExtraID = 0x0001 // Zip64 extended information
+	// Versions needed to extract, in ReaderVersion.
+	zipVersion20 = 20 // 2.0
+	zipVersion45 = 45 // 4.5 (reads and writes zip64 archives)
+
+	// Limits of the fields that zip64 extends.
+	uint16max = (1 << 16) - 1
+	uint32max = (1 << 32) - 1
+
+	zip64ExtraID   = 0x0001 // Zip64 extended information
+	extTimeExtraID = 0x5455 // Extended timestamp
+
+	// Constants for the first byte in CreatorVersion.
+	creatorFAT    = 0
+	creatorUnix   = 3
+	creatorNTFS   = 11
+	creatorVFAT   = 14
+	creatorMacOSX = 19
 )
 
+// FileHeaderLen is the size of a local file header, without the name and
+// extra field that follow it.
+const FileHeaderLen = fileHeaderLen
+
 // Compression methods.
 const (
 	Store   uint16 = 0 // no compression
@@ -45,24 +77,206 @@ type FileHeader struct {
 	// Comment is any arbitrary user-defined string shorter than 64KiB.
 	Comment string
 
-	Flags uint16
+	CreatorVersion uint16
+	ReaderVersion  uint16
+	Flags          uint16
 
 	// Method is the compression method. If zero, Store is used.
 	Method uint16
 
+	// Modified is the modified time of the file. It is taken from the
+	// extended timestamp field when present, otherwise from the legacy
+	// MS-DOS date and time fields.
+	Modified     time.Time
+	ModifiedTime uint16 // MS-DOS time
+	ModifiedDate uint16 // MS-DOS date
+
+	CRC32              uint32
 	CompressedSize     uint32 // Deprecated: Use CompressedSize64 instead.
 	UncompressedSize   uint32 // Deprecated: Use UncompressedSize64 instead.
 	CompressedSize64   uint64
 	UncompressedSize64 uint64
 	Extra              []byte
+	InternalAttrs      uint16 // Bit 0 set for text files
+	ExternalAttrs      uint32 // Meaning depends on CreatorVersion
+}
+
+// FileInfo returns an fs.FileInfo for the FileHeader.
+func (h *FileHeader) FileInfo() fs.FileInfo {
+	return headerFileInfo{h}
+}
+
+// headerFileInfo implements fs.FileInfo.
+type headerFileInfo struct {
+	fh *FileHeader
+}
+
+func (fi headerFileInfo) Name() string { return path.Base(fi.fh.Name) }
+func (fi headerFileInfo) Size() int64 {
+	if fi.fh.UncompressedSize64 > 0 {
+		return int64(fi.fh.UncompressedSize64)
+	}
+	return int64(fi.fh.UncompressedSize)
+}
+func (fi headerFileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi headerFileInfo) ModTime() time.Time { return fi.fh.Modified.UTC() }
+func (fi headerFileInfo) Mode() fs.FileMode  { return fi.fh.Mode() }
+func (fi headerFileInfo) Type() fs.FileMode  { return fi.fh.Mode().Type() }
+func (fi headerFileInfo) Sys() interface{}   { return fi.fh }
+
+func (fi headerFileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// Mode returns the permission and mode bits for the FileHeader.
+func (h *FileHeader) Mode() (mode fs.FileMode) {
+	switch h.CreatorVersion >> 8 {
+	case creatorUnix, creatorMacOSX:
+		mode = unixModeToFileMode(h.ExternalAttrs >> 16)
+	case creatorNTFS, creatorVFAT, creatorFAT:
+		mode = msdosModeToFileMode(h.ExternalAttrs)
+	}
+	if len(h.Name) > 0 && h.Name[len(h.Name)-1] == '/' {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
+
+// SetMode changes the permission and mode bits for the FileHeader.
+func (h *FileHeader) SetMode(mode fs.FileMode) {
+	h.CreatorVersion = h.CreatorVersion&0xff | creatorUnix<<8
+	h.ExternalAttrs = fileModeToUnixMode(mode) << 16
+
+	// set MSDOS attributes too, as the original zip does.
+	if mode&fs.ModeDir != 0 {
+		h.ExternalAttrs |= msdosDir
+	}
+	if mode&0200 == 0 {
+		h.ExternalAttrs |= msdosReadOnly
+	}
+}
+
+// msDosTimeToTime converts an MS-DOS date and time into a time.Time.
+// The resolution is 2s.
+// See: https://msdn.microsoft.com/en-us/library/ms724247(v=VS.85).aspx
+func msDosTimeToTime(dosDate, dosTime uint16) time.Time {
+	return time.Date(
+		// date bits 0-4: day of month; 5-8: month; 9-15: years since 1980
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+
+		// time bits 0-4: second/2; 5-10: minute; 11-15: hour
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0, // nanoseconds
+
+		time.UTC,
+	)
+}
+
+const (
+	// Unix constants. The specification doesn't mention them,
+	// but these seem to be the values agreed on by tools.
+	sIFMT   = 0xf000
+	sIFSOCK = 0xc000
+	sIFLNK  = 0xa000
+	sIFREG  = 0x8000
+	sIFBLK  = 0x6000
+	sIFDIR  = 0x4000
+	sIFCHR  = 0x2000
+	sIFIFO  = 0x1000
+	sISUID  = 0x800
+	sISGID  = 0x400
+	sISVTX  = 0x200
+
+	msdosDir      = 0x10
+	msdosReadOnly = 0x01
+)
+
+func msdosModeToFileMode(m uint32) (mode fs.FileMode) {
+	if m&msdosDir != 0 {
+		mode = fs.ModeDir | 0777
+	} else {
+		mode = 0666
+	}
+	if m&msdosReadOnly != 0 {
+		mode &^= 0222
+	}
+	return mode
+}
+
+func fileModeToUnixMode(mode fs.FileMode) uint32 {
+	var m uint32
+	switch mode & fs.ModeType {
+	default:
+		m = sIFREG
+	case fs.ModeDir:
+		m = sIFDIR
+	case fs.ModeSymlink:
+		m = sIFLNK
+	case fs.ModeNamedPipe:
+		m = sIFIFO
+	case fs.ModeSocket:
+		m = sIFSOCK
+	case fs.ModeDevice:
+		m = sIFBLK
+	case fs.ModeDevice | fs.ModeCharDevice:
+		m = sIFCHR
+	}
+	if mode&fs.ModeSetuid != 0 {
+		m |= sISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= sISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= sISVTX
+	}
+	return m | uint32(mode&0777)
+}
+
+func unixModeToFileMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	switch m & sIFMT {
+	case sIFBLK:
+		mode |= fs.ModeDevice
+	case sIFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case sIFDIR:
+		mode |= fs.ModeDir
+	case sIFIFO:
+		mode |= fs.ModeNamedPipe
+	case sIFLNK:
+		mode |= fs.ModeSymlink
+	case sIFREG:
+		// nothing to do
+	case sIFSOCK:
+		mode |= fs.ModeSocket
+	}
+	if m&sISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&sISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&sISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
 }
 
 // DirectoryEnd descrives an EOCD record
 type DirectoryEnd struct {
+	DiskNumber         uint32 // number of this disk; the last of a split archive
+	DirectoryDisk      uint32 // disk on which the central directory starts
 	directoryRecords   uint64
 	directorySize      uint64
-	DirectoryOffset    uint64 // relative to file
+	DirectoryOffset    uint64 // relative to file, or to DirectoryDisk if split
+	BaseOffset         int64  // offset of the archive's start in the file, for prepended data
 	DirectoryEndOffset uint64
 	commentLen         uint16
 	comment            string
 }
+
+// Comment returns the archive's comment.
+func (d *DirectoryEnd) Comment() string { return d.comment }
@@ -0,0 +1,288 @@
+package reader
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// FileInfoHeader creates a partially-populated FileHeader from an
+// fs.FileInfo, as archive/zip's does: Name is only the base name of the
+// file, so the caller will usually want to set it to the file's full path
+// in the archive. The modification time is set in the MS-DOS fields and,
+// more precisely, in an extended timestamp field, and Method is Deflate.
+func FileInfoHeader(fi fs.FileInfo) (*FileHeader, error) {
+	size := fi.Size()
+	if size < 0 {
+		return nil, errors.New("zip: negative file size")
+	}
+	fh := &FileHeader{
+		Name:               fi.Name(),
+		CreatorVersion:     zipVersion20,
+		ReaderVersion:      zipVersion20,
+		Method:             Deflate,
+		UncompressedSize64: uint64(size),
+	}
+	fh.SetMode(fi.Mode())
+	fh.Modified = fi.ModTime()
+	fh.ModifiedDate, fh.ModifiedTime = timeToMsDosTime(fh.Modified)
+	var ext [9]byte
+	b := writeBuf(ext[:])
+	b.uint16(extTimeExtraID)
+	b.uint16(5) // size
+	b.uint8(1)  // flags: the modification time follows
+	b.uint32(uint32(fh.Modified.Unix()))
+	fh.Extra = append(fh.Extra, ext[:]...)
+	return fh, nil
+}
+
+// timeToMsDosTime converts a time.Time to an MS-DOS date and time, in the
+// time's own location. The resolution is 2s.
+func timeToMsDosTime(t time.Time) (fDate uint16, fTime uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	fDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return
+}
+
+// NewCompressor returns a writer that compresses what is written to it
+// with the compressor registered for method and writes the result to w.
+// It must be closed to flush the compressed data.
+func NewCompressor(w io.Writer, method uint16) (io.WriteCloser, error) {
+	comp := compressor(method)
+	if comp == nil {
+		return nil, ErrAlgorithm
+	}
+	return comp(w)
+}
+
+// WriteFileHeader writes the local file header for h, which comes right
+// before the file's data. The CRC-32 and sizes must be known; sizes too
+// large for the header are written in a zip64 extra field.
+func WriteFileHeader(w io.Writer, h *FileHeader) error {
+	if len(h.Name) > uint16max {
+		return errors.New("zip: FileHeader.Name too long")
+	}
+	extra := withoutZip64(h.Extra)
+	version := h.ReaderVersion
+	csize, usize := uint32(h.CompressedSize64), uint32(h.UncompressedSize64)
+	if h.CompressedSize64 >= uint32max || h.UncompressedSize64 >= uint32max {
+		// Both sizes must be in the extra field of a local header.
+		version = zip64Version(version)
+		csize, usize = uint32max, uint32max
+		var buf [20]byte
+		b := writeBuf(buf[:])
+		b.uint16(zip64ExtraID)
+		b.uint16(16) // size
+		b.uint64(h.UncompressedSize64)
+		b.uint64(h.CompressedSize64)
+		extra = append(buf[:], extra...)
+	}
+	if len(extra) > uint16max {
+		return errors.New("zip: FileHeader.Extra too long")
+	}
+
+	var buf [fileHeaderLen]byte
+	b := writeBuf(buf[:])
+	b.uint32(fileHeaderSignature)
+	b.uint16(version)
+	b.uint16(h.Flags &^ 0x8) // the sizes are here, not in a data descriptor
+	b.uint16(h.Method)
+	b.uint16(h.ModifiedTime)
+	b.uint16(h.ModifiedDate)
+	b.uint32(h.CRC32)
+	b.uint32(csize)
+	b.uint32(usize)
+	b.uint16(uint16(len(h.Name)))
+	b.uint16(uint16(len(extra)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, h.Name); err != nil {
+		return err
+	}
+	_, err := w.Write(extra)
+	return err
+}
+
+// WriteDirectoryHeader writes the central directory header for h, whose
+// local header is at offset. Values too large for the header are written
+// in a zip64 extra field, replacing any h has.
+func WriteDirectoryHeader(w io.Writer, h *FileHeader, offset int64) error {
+	if len(h.Name) > uint16max {
+		return errors.New("zip: FileHeader.Name too long")
+	}
+	if len(h.Comment) > uint16max {
+		return errors.New("zip: FileHeader.Comment too long")
+	}
+	extra := withoutZip64(h.Extra)
+	version := h.ReaderVersion
+	csize, usize, off := uint32(h.CompressedSize64), uint32(h.UncompressedSize64), uint32(offset)
+	var z64 []byte
+	var field [8]byte
+	if h.UncompressedSize64 >= uint32max {
+		usize = uint32max
+		binary.LittleEndian.PutUint64(field[:], h.UncompressedSize64)
+		z64 = append(z64, field[:]...)
+	}
+	if h.CompressedSize64 >= uint32max {
+		csize = uint32max
+		binary.LittleEndian.PutUint64(field[:], h.CompressedSize64)
+		z64 = append(z64, field[:]...)
+	}
+	if offset >= uint32max {
+		off = uint32max
+		binary.LittleEndian.PutUint64(field[:], uint64(offset))
+		z64 = append(z64, field[:]...)
+	}
+	if len(z64) > 0 {
+		version = zip64Version(version)
+		var head [4]byte
+		b := writeBuf(head[:])
+		b.uint16(zip64ExtraID)
+		b.uint16(uint16(len(z64)))
+		extra = append(append(head[:], z64...), extra...)
+	}
+	if len(extra) > uint16max {
+		return errors.New("zip: FileHeader.Extra too long")
+	}
+
+	var buf [directoryHeaderLen]byte
+	b := writeBuf(buf[:])
+	b.uint32(directoryHeaderSignature)
+	b.uint16(h.CreatorVersion)
+	b.uint16(version)
+	b.uint16(h.Flags)
+	b.uint16(h.Method)
+	b.uint16(h.ModifiedTime)
+	b.uint16(h.ModifiedDate)
+	b.uint32(h.CRC32)
+	b.uint32(csize)
+	b.uint32(usize)
+	b.uint16(uint16(len(h.Name)))
+	b.uint16(uint16(len(extra)))
+	b.uint16(uint16(len(h.Comment)))
+	b = b[2:] // skip disk number start
+	b.uint16(h.InternalAttrs)
+	b.uint32(h.ExternalAttrs)
+	b.uint32(off)
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, h.Name); err != nil {
+		return err
+	}
+	if _, err := w.Write(extra); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, h.Comment)
+	return err
+}
+
+// WriteDirectoryEnd writes the end of central directory record for a
+// directory of the given number of records and size starting at offset,
+// preceded by a zip64 end of central directory record and locator if any
+// of those don't fit in it.
+func WriteDirectoryEnd(w io.Writer, records, size, offset int64, comment string) error {
+	if len(comment) > uint16max {
+		return ErrCommentLength
+	}
+	if records >= uint16max || size >= uint32max || offset >= uint32max {
+		var buf [directory64EndLen + directory64LocLen]byte
+		b := writeBuf(buf[:])
+		b.uint32(directory64EndSignature)
+		b.uint64(directory64EndLen - 12) // size of the rest of the record
+		b.uint16(zipVersion45)           // version made by
+		b.uint16(zipVersion45)           // version needed to extract
+		b.uint32(0)                      // number of this disk
+		b.uint32(0)                      // disk with the central directory
+		b.uint64(uint64(records))        // records on this disk
+		b.uint64(uint64(records))        // records in total
+		b.uint64(uint64(size))
+		b.uint64(uint64(offset))
+
+		b.uint32(directory64LocSignature)
+		b.uint32(0)                     // disk with the zip64 record
+		b.uint64(uint64(offset + size)) // where it is
+		b.uint32(1)                     // number of disks
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+		records, size, offset = minInt64(records, uint16max), minInt64(size, uint32max), minInt64(offset, uint32max)
+	}
+
+	var buf [directoryEndLen]byte
+	b := writeBuf(buf[:])
+	b.uint32(directoryEndSignature)
+	b = b[4:]                 // skip number of this disk and disk with the directory
+	b.uint16(uint16(records)) // records on this disk
+	b.uint16(uint16(records)) // records in total
+	b.uint32(uint32(size))
+	b.uint32(uint32(offset))
+	b.uint16(uint16(len(comment)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, comment)
+	return err
+}
+
+// withoutZip64 returns the extra fields other than zip64's, which the
+// writers replace.
+func withoutZip64(extra []byte) []byte {
+	var kept []byte
+	for b := readBuf(extra); len(b) >= 4; {
+		field := b
+		tag := b.uint16()
+		size := int(b.uint16())
+		if len(b) < size {
+			// malformed; keep the rest as is
+			return append(kept, field...)
+		}
+		b = b[size:]
+		if tag != zip64ExtraID {
+			kept = append(kept, field[:4+size]...)
+		}
+	}
+	return kept
+}
+
+func zip64Version(v uint16) uint16 {
+	if v < zipVersion45 {
+		return zipVersion45
+	}
+	return v
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type writeBuf []byte
+
+func (b *writeBuf) uint8(v uint8) {
+	(*b)[0] = v
+	*b = (*b)[1:]
+}
+
+func (b *writeBuf) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(*b, v)
+	*b = (*b)[2:]
+}
+
+func (b *writeBuf) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(*b, v)
+	*b = (*b)[4:]
+}
+
+func (b *writeBuf) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(*b, v)
+	*b = (*b)[8:]
+}
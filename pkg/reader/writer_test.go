@@ -0,0 +1,111 @@
+package reader
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// sparse is an archive too large to hold in memory: zeros, but for the
+// given parts.
+type sparse struct {
+	size  int64
+	parts map[int64][]byte // by offset
+}
+
+func (s *sparse) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > s.size-off {
+		n = int(s.size - off)
+	}
+	for i := range p[:n] {
+		p[i] = 0
+	}
+	for at, b := range s.parts {
+		if at < off+int64(n) && at+int64(len(b)) > off {
+			start := at - off
+			src := b
+			if start < 0 {
+				src, start = b[-start:], 0
+			}
+			copy(p[start:n], src)
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestWriteZip64(t *testing.T) {
+	// An entry with sizes too large for its headers, followed by one whose
+	// local header is past 4 GiB, as is the directory.
+	big := &FileHeader{Name: "big", Method: Store, CompressedSize64: 5 << 30, UncompressedSize64: 5 << 30}
+	body := []byte("past the 32-bit offsets")
+	far := &FileHeader{Name: "far", Method: Store, CRC32: crc32.ChecksumIEEE(body),
+		CompressedSize64: uint64(len(body)), UncompressedSize64: uint64(len(body))}
+
+	s := &sparse{parts: make(map[int64][]byte)}
+	var buf bytes.Buffer
+	if err := WriteFileHeader(&buf, big); err != nil {
+		t.Fatal(err)
+	}
+	bigData := int64(buf.Len())
+	s.parts[0] = append([]byte(nil), buf.Bytes()...)
+
+	farOffset := bigData + int64(big.CompressedSize64)
+	buf.Reset()
+	if err := WriteFileHeader(&buf, far); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(body)
+	s.parts[farOffset] = append([]byte(nil), buf.Bytes()...)
+
+	dirOffset := farOffset + int64(buf.Len())
+	buf.Reset()
+	if err := WriteDirectoryHeader(&buf, big, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDirectoryHeader(&buf, far, farOffset); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDirectoryEnd(&buf, 2, int64(buf.Len()), dirOffset, "zip64"); err != nil {
+		t.Fatal(err)
+	}
+	s.parts[dirOffset] = buf.Bytes()
+	s.size = dirOffset + int64(buf.Len())
+
+	zr, err := zip.NewReader(s, s.size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.Comment != "zip64" {
+		t.Fatalf("read %d entries and comment %q; want 2 and %q", len(zr.File), zr.Comment, "zip64")
+	}
+	f := zr.File[0]
+	if f.Name != "big" || f.CompressedSize64 != big.CompressedSize64 || f.UncompressedSize64 != big.UncompressedSize64 {
+		t.Errorf("first entry %s has sizes %d and %d; want big with %d", f.Name, f.CompressedSize64, f.UncompressedSize64, big.CompressedSize64)
+	}
+	if off, err := f.DataOffset(); err != nil || off != bigData {
+		t.Errorf("big's data is at %d, %v; want %d", off, err, bigData)
+	}
+	f = zr.File[1]
+	if off, err := f.DataOffset(); err != nil || off != farOffset+fileHeaderLen+int64(len(far.Name)) {
+		t.Errorf("far's data is at %d, %v; want after its local header at %d", off, err, farOffset)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(b, body) {
+		t.Errorf("far read %q, %v; want %q", b, err, body)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/alec-rabold/zipspy/pkg/zipfile"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server serves the entries of one or more zip archives in S3 over HTTP.
+// Entries are available at /<archive>/<entry path> and are range-fetched and
+// decompressed on demand; each archive's central directory is held in memory.
+type Server struct {
+	archives map[string]*zipfile.FS // archive name -> archive
+}
+
+// New creates a Server for the given archives, keyed by the name they are
+// served under.
+func New(archives map[string]*zipfile.FS) *Server {
+	return &Server{archives: archives}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	upath := path.Clean("/" + r.URL.Path)
+	if upath == "/" {
+		s.serveArchiveList(w, r)
+		return
+	}
+	parts := strings.SplitN(upath[1:], "/", 2)
+	fsys, ok := s.archives[parts[0]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// stop fetching from S3 if the client goes away
+	fsys = fsys.WithContext(r.Context())
+	name := "."
+	if len(parts) == 2 {
+		name = parts[1]
+	}
+	fi, err := fsys.Stat(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(upath)+"/", http.StatusMovedPermanently)
+			return
+		}
+		s.serveDir(w, r, fsys, name)
+		return
+	}
+	s.serveFile(w, r, fsys, name, fi)
+}
+
+// serveFile writes a single entry. Stored entries are served with
+// http.ServeContent so that Range requests work; compressed entries are
+// decompressed as they are fetched, never held in memory whole.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, fsys *zipfile.FS, name string, fi fs.FileInfo) {
+	h := w.Header()
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	// Entries without a CRC-32, as in stargz archives, go without an ETag,
+	// as one made from the size alone would match changed contents.
+	if fh, ok := fi.Sys().(*reader.File); ok && (&zipfile.Entry{FileHeader: fh.FileHeader}).HasCRC() {
+		h.Set("ETag", fmt.Sprintf(`"%08x-%x"`, fh.CRC32, fh.UncompressedSize64))
+	}
+	if notModified(r, h.Get("ETag"), fi.ModTime()) {
+		writeNotModified(w)
+		return
+	}
+
+	// The central directory has everything a HEAD needs, so don't fetch the
+	// body unless the content type has to be sniffed from it.
+	if r.Method == http.MethodHead && h.Get("Content-Type") != "" {
+		setLastModified(w, fi.ModTime())
+		h.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		return
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		log.Errorf("error opening entry (name: %s), err: %v", name, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer f.Close()
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, fi.ModTime(), rs)
+		return
+	}
+
+	br := bufio.NewReader(f)
+	if h.Get("Content-Type") == "" {
+		head, _ := br.Peek(512)
+		h.Set("Content-Type", http.DetectContentType(head))
+	}
+	setLastModified(w, fi.ModTime())
+	h.Set("Accept-Ranges", "none")
+	h.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		log.Errorf("error writing entry (name: %s), err: %v", name, err)
+	}
+}
+
+var dirTemplate = template.Must(template.New("dir").Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>{{.Title}}</title>
+<h1>{{.Title}}</h1>
+<pre>
+{{if .Parent}}<a href="../">../</a>
+{{end}}{{range .Entries}}<a href="{{.Href}}">{{.Name}}</a>{{if not .IsDir}}	{{.Size}}	{{.Modified}}{{end}}
+{{end}}</pre>
+`))
+
+type dirEntry struct {
+	Name     string
+	Href     string
+	IsDir    bool
+	Size     int64
+	Modified string
+}
+
+// serveDir writes an HTML listing of a directory within an archive.
+func (s *Server) serveDir(w http.ResponseWriter, r *http.Request, fsys *zipfile.FS, name string) {
+	entries, err := fsys.ReadDir(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var list []dirEntry
+	for _, e := range entries {
+		d := dirEntry{Name: e.Name(), IsDir: e.IsDir()}
+		if d.IsDir {
+			d.Name += "/"
+		} else if fi, err := e.Info(); err == nil {
+			d.Size = fi.Size()
+			d.Modified = fi.ModTime().Format(time.RFC3339)
+		}
+		d.Href = (&url.URL{Path: d.Name}).String()
+		list = append(list, d)
+	}
+	writeListing(w, r, path.Clean(r.URL.Path), true, list)
+}
+
+// serveArchiveList writes an HTML listing of the served archives.
+func (s *Server) serveArchiveList(w http.ResponseWriter, r *http.Request) {
+	var list []dirEntry
+	for name := range s.archives {
+		list = append(list, dirEntry{Name: name + "/", Href: (&url.URL{Path: name + "/"}).String(), IsDir: true})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeListing(w, r, "/", false, list)
+}
+
+func writeListing(w http.ResponseWriter, r *http.Request, title string, parent bool, list []dirEntry) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := dirTemplate.Execute(w, struct {
+		Title   string
+		Parent  bool
+		Entries []dirEntry
+	}{title, parent, list})
+	if err != nil {
+		log.Errorf("error writing directory listing (path: %s), err: %v", title, err)
+	}
+}
+
+func setLastModified(w http.ResponseWriter, modtime time.Time) {
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified reports whether the request's conditional headers are
+// satisfied by the given ETag and modification time.
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || (etag != "" && strings.TrimPrefix(t, "W/") == etag) {
+				return true
+			}
+		}
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modtime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modtime.Truncate(time.Second).After(t)
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,208 @@
+// Package stargz parses the table of contents of stargz and eStargz
+// archives.
+//
+// A stargz archive is a tar.gz made of many gzip members: each regular
+// file's contents (or each chunk of a large file) starts a new member, and a
+// JSON table of contents in its own member near the end of the archive maps
+// every file to the offsets of those members. A small footer, itself an
+// empty gzip member, records where the table of contents starts, so both it
+// and any single file can be read with range requests.
+package stargz
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// FooterSize is the size of an eStargz footer
+	FooterSize = 51
+	// legacyFooterSize is the size of the original stargz footer
+	legacyFooterSize = 47
+
+	// TOCTarName is the name of the table of contents inside its tar stream
+	TOCTarName = "stargz.index.json"
+)
+
+// ErrFormat indicates data that is not a stargz archive
+var ErrFormat = errors.New("stargz: not a stargz archive")
+
+// TOC is the table of contents of a stargz archive.
+type TOC struct {
+	Version int         `json:"version"`
+	Entries []*TOCEntry `json:"entries"`
+
+	// TOCOffset is the offset of the gzip member holding the TOC itself,
+	// which is where the last file's data ends.
+	TOCOffset int64 `json:"-"`
+}
+
+// TOCEntry is an entry in the table of contents. Regular files larger than
+// the archive's chunk size are followed by entries of type "chunk" with the
+// same name.
+type TOCEntry struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // dir, reg, symlink, hardlink, char, block, fifo or chunk
+	Size        int64  `json:"size,omitempty"`
+	ModTime3339 string `json:"modtime,omitempty"`
+	LinkName    string `json:"linkName,omitempty"`
+	Mode        int64  `json:"mode,omitempty"`
+	UID         int    `json:"uid,omitempty"`
+	GID         int    `json:"gid,omitempty"`
+	Offset      int64  `json:"offset,omitempty"` // of the gzip member holding this chunk
+	ChunkOffset int64  `json:"chunkOffset,omitempty"`
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+}
+
+// ModTime returns the entry's modification time.
+func (e *TOCEntry) ModTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, e.ModTime3339)
+	return t
+}
+
+// Chunk is the part of a regular file's contents held in one gzip member.
+type Chunk struct {
+	Offset      int64 // of the gzip member in the archive
+	End         int64 // end of the gzip member's compressed bytes in the archive
+	ChunkOffset int64 // offset of the chunk within the file
+	ChunkSize   int64
+}
+
+// ParseFooter returns the offset of the table of contents and the size of
+// the footer, given at least the last FooterSize bytes of an archive.
+func ParseFooter(b []byte) (tocOffset int64, footerSize int, err error) {
+	if len(b) >= FooterSize {
+		if off, err := parseFooter(b[len(b)-FooterSize:], true); err == nil {
+			return off, FooterSize, nil
+		}
+	}
+	if len(b) >= legacyFooterSize {
+		if off, err := parseFooter(b[len(b)-legacyFooterSize:], false); err == nil {
+			return off, legacyFooterSize, nil
+		}
+	}
+	return 0, 0, ErrFormat
+}
+
+// parseFooter parses a footer: an empty gzip member whose extra field holds
+// "%016xSTARGZ", wrapped in an "SG" subfield for eStargz.
+func parseFooter(b []byte, estargz bool) (int64, error) {
+	if len(b) < 12 || b[0] != 0x1f || b[1] != 0x8b || b[3]&4 == 0 {
+		return 0, ErrFormat
+	}
+	extra := b[12:]
+	if xlen := int(binary.LittleEndian.Uint16(b[10:12])); xlen <= len(extra) {
+		extra = extra[:xlen]
+	} else {
+		return 0, ErrFormat
+	}
+	if estargz {
+		if len(extra) < 4 || extra[0] != 'S' || extra[1] != 'G' ||
+			int(binary.LittleEndian.Uint16(extra[2:4])) != len(extra)-4 {
+			return 0, ErrFormat
+		}
+		extra = extra[4:]
+	}
+	if len(extra) != 22 || string(extra[16:]) != "STARGZ" {
+		return 0, ErrFormat
+	}
+	off, err := strconv.ParseInt(string(extra[:16]), 16, 64)
+	if err != nil {
+		return 0, ErrFormat
+	}
+	return off, nil
+}
+
+// ReadTOC reads the table of contents from r, the gzip member at
+// tocOffset.
+func ReadTOC(r io.Reader, tocOffset int64) (*TOC, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrFormat, err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	h, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrFormat, err)
+	}
+	if h.Name != TOCTarName {
+		return nil, fmt.Errorf("%v: unexpected TOC name %q", ErrFormat, h.Name)
+	}
+	toc := &TOC{TOCOffset: tocOffset}
+	if err := json.NewDecoder(tr).Decode(toc); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrFormat, err)
+	}
+	for _, e := range toc.Entries {
+		e.Name = CleanName(e.Name)
+		if e.Type == "hardlink" {
+			e.LinkName = CleanName(e.LinkName)
+		}
+	}
+	return toc, nil
+}
+
+// CleanName returns the canonical form of a tar entry name: slash separated,
+// without leading "./" or "/" and without a trailing slash.
+func CleanName(name string) string {
+	name = path.Clean("/" + name)
+	return name[1:]
+}
+
+// Chunks returns the chunks making up each regular file, keyed by name, in
+// order. The compressed extent of each chunk runs up to the next gzip member
+// referenced by the TOC, or to the TOC itself for the last one.
+func (toc *TOC) Chunks() map[string][]Chunk {
+	var offsets []int64
+	for _, e := range toc.Entries {
+		if (e.Type == "reg" || e.Type == "chunk") && e.Offset > 0 {
+			offsets = append(offsets, e.Offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	end := func(off int64) int64 {
+		i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > off })
+		if i < len(offsets) {
+			return offsets[i]
+		}
+		return toc.TOCOffset
+	}
+
+	chunks := make(map[string][]Chunk)
+	sizes := make(map[string]int64)
+	for _, e := range toc.Entries {
+		switch e.Type {
+		case "reg":
+			sizes[e.Name] = e.Size
+			if e.Size == 0 {
+				chunks[e.Name] = nil
+				continue
+			}
+		case "chunk":
+		default:
+			continue
+		}
+		size := e.ChunkSize
+		if size == 0 {
+			size = sizes[e.Name] - e.ChunkOffset
+		}
+		chunks[e.Name] = append(chunks[e.Name], Chunk{
+			Offset:      e.Offset,
+			End:         end(e.Offset),
+			ChunkOffset: e.ChunkOffset,
+			ChunkSize:   size,
+		})
+	}
+	return chunks
+}
@@ -0,0 +1,83 @@
+package stargz
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// footer returns a footer pointing at tocOffset, in the eStargz form if
+// estargz is set.
+func footer(tocOffset int64, estargz bool) []byte {
+	extra := []byte(fmt.Sprintf("%016xSTARGZ", tocOffset))
+	if estargz {
+		extra = append([]byte{'S', 'G', byte(len(extra)), 0}, extra...)
+	}
+	b := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 0, 0}
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(extra)))
+	b = append(b, extra...)
+	return append(b, 1, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func TestParseFooter(t *testing.T) {
+	for _, estargz := range []bool{false, true} {
+		want := legacyFooterSize
+		if estargz {
+			want = FooterSize
+		}
+		b := footer(0x1234, estargz)
+		if len(b) != want {
+			t.Fatalf("estargz %v: footer is %d bytes; want %d", estargz, len(b), want)
+		}
+		// Preceded by the end of the archive, as read from S3.
+		off, size, err := ParseFooter(append(make([]byte, 8), b...))
+		if err != nil || off != 0x1234 || size != want {
+			t.Errorf("estargz %v: ParseFooter = %#x, %d, %v; want 0x1234, %d", estargz, off, size, err, want)
+		}
+		if _, _, err := ParseFooter(b[1:]); err != ErrFormat {
+			t.Errorf("estargz %v: truncated footer: err = %v; want %v", estargz, err, ErrFormat)
+		}
+		for _, c := range []struct {
+			name string
+			i    int
+			v    byte
+		}{
+			{"gzip magic", 0, 0},
+			{"no extra flag", 3, 0},
+			{"extra length", 10, 21},
+			{"STARGZ marker", len(b) - 14, 'X'},
+			{"hex offset", len(b) - 20, 'g'},
+		} {
+			bad := append([]byte(nil), b...)
+			bad[c.i] = c.v
+			if _, _, err := ParseFooter(bad); err != ErrFormat {
+				t.Errorf("estargz %v, bad %s: err = %v; want %v", estargz, c.name, err, ErrFormat)
+			}
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	toc := &TOC{TOCOffset: 1000, Entries: []*TOCEntry{
+		{Name: "dir", Type: "dir"},
+		{Name: "a", Type: "reg", Size: 10, Offset: 100},
+		{Name: "big", Type: "reg", Size: 25, Offset: 200, ChunkSize: 10},
+		{Name: "big", Type: "chunk", Offset: 300, ChunkOffset: 10, ChunkSize: 10},
+		{Name: "big", Type: "chunk", Offset: 400, ChunkOffset: 20},
+		{Name: "empty", Type: "reg"},
+		{Name: "link", Type: "symlink", LinkName: "a"},
+	}}
+	want := map[string][]Chunk{
+		"a": {{Offset: 100, End: 200, ChunkSize: 10}},
+		"big": {
+			{Offset: 200, End: 300, ChunkSize: 10},
+			{Offset: 300, End: 400, ChunkOffset: 10, ChunkSize: 10},
+			{Offset: 400, End: 1000, ChunkOffset: 20, ChunkSize: 5},
+		},
+		"empty": nil,
+	}
+	if got := toc.Chunks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Chunks() = %+v; want %+v", got, want)
+	}
+}
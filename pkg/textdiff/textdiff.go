@@ -0,0 +1,199 @@
+// Package textdiff compares texts line by line, writing their differences
+// in the unified format of diff -u.
+package textdiff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxChanges is the most lines deleted and inserted that Unified finds.
+const MaxChanges = 2000
+
+// ErrTooManyChanges is returned by Unified for texts that differ in more
+// than MaxChanges lines.
+var ErrTooManyChanges = errors.New("textdiff: too many changes")
+
+// Unified returns the differences between texts a and b in unified format,
+// with the given number of lines of context around each change and headed
+// by "--- aName" and "+++ bName". It returns "" if the texts are equal.
+//
+// The differences are found with Myers' algorithm, which takes memory in
+// proportion to the square of the number of lines that differ, so Unified
+// gives up with ErrTooManyChanges beyond MaxChanges of them.
+func Unified(aName, bName, a, b string, context int) (string, error) {
+	if a == b {
+		return "", nil
+	}
+	edits := diff(lines(a), lines(b), MaxChanges)
+	if edits == nil {
+		return "", ErrTooManyChanges
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks(edits, context) {
+		first := edits[h.start]
+		var aCount, bCount int
+		for _, e := range edits[h.start:h.end] {
+			if e.op != insert {
+				aCount++
+			}
+			if e.op != delete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(first.a, aCount), hunkRange(first.b, bCount))
+		for _, e := range edits[h.start:h.end] {
+			sb.WriteByte(byte(e.op))
+			sb.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// lines splits s after each newline.
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+const (
+	equal  = ' '
+	delete = '-'
+	insert = '+'
+)
+
+// edit is a line kept, deleted from a or inserted from b. a and b are the
+// numbers of lines of each text that come before it.
+type edit struct {
+	op   rune
+	a, b int
+	text string
+}
+
+// diff returns the shortest list of edits turning a into b, found as in
+// "An O(ND) Difference Algorithm and Its Variations" (Myers, 1986), or nil
+// if it has more than max deletions and insertions.
+func diff(a, b []string, max int) []edit {
+	n, m := len(a), len(b)
+	if n+m < max {
+		max = n + m
+	}
+	off := max + 1
+	v := make([]int, 2*max+3) // furthest x reached on each diagonal k = x - y
+	// trace[d] holds v[-d-1..d+1] as it was before step d
+	var trace [][]int
+	d := 0
+search:
+	for ; ; d++ {
+		if d > max {
+			return nil
+		}
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1] // down, inserting from b
+			} else {
+				x = v[off+k-1] + 1 // right, deleting from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end, collecting the edits in reverse.
+	var edits []edit
+	x, y := n, m
+	for ; d >= 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+		k := x - y
+		var pk int
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := at(pk)
+		py := px - pk
+		for x > px && y > py {
+			x--
+			y--
+			edits = append(edits, edit{equal, x, y, a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == px {
+			edits = append(edits, edit{insert, px, py, b[py]})
+		} else {
+			edits = append(edits, edit{delete, px, py, a[px]})
+		}
+		x, y = px, py
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// hunk is the edits [start, end) printed together.
+type hunk struct {
+	start, end int
+}
+
+// hunks groups the changes in edits with up to context unchanged lines on
+// either side, joining groups whose context would overlap or touch.
+func hunks(edits []edit, context int) []hunk {
+	var hs []hunk
+	for i, e := range edits {
+		if e.op == equal {
+			continue
+		}
+		start, end := i-context, i+1+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+		if len(hs) > 0 && start <= hs[len(hs)-1].end {
+			hs[len(hs)-1].end = end
+			continue
+		}
+		hs = append(hs, hunk{start, end})
+	}
+	return hs
+}
+
+// hunkRange formats the start and length of a hunk in one text, as diff
+// does: lines are numbered from 1, and an empty range starts at the line
+// before it.
+func hunkRange(before, count int) string {
+	start := before + 1
+	if count == 0 {
+		start = before
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
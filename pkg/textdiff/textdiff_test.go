@@ -0,0 +1,112 @@
+package textdiff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", 3, ""},
+		{"change", "a\nb\nc\n", "a\nx\nc\n", 3, "--- A\n+++ B\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"no context", "a\nb\nc\n", "a\nx\nc\n", 0, "--- A\n+++ B\n@@ -2 +2 @@\n-b\n+x\n"},
+		{"insert into empty", "", "a\n", 3, "--- A\n+++ B\n@@ -0,0 +1 @@\n+a\n"},
+		{"delete all", "a\nb\n", "", 3, "--- A\n+++ B\n@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"no newline at end", "a\nb", "a\nb\n", 1, "--- A\n+++ B\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{
+			"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n", "x\n2\n3\n4\n5\n6\n7\ny\n", 1,
+			"--- A\n+++ B\n@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+y\n",
+		},
+		{
+			"joined hunks", "1\n2\n3\n4\n5\n", "x\n2\n3\n4\ny\n", 2,
+			"--- A\n+++ B\n@@ -1,5 +1,5 @@\n-1\n+x\n 2\n 3\n 4\n-5\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := Unified("A", "B", tt.a, tt.b, tt.context)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Unified = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// randomText returns up to 30 lines drawn from a few, so that texts share
+// many lines.
+func randomText(r *rand.Rand) string {
+	var sb strings.Builder
+	for i := r.Intn(30); i > 0; i-- {
+		sb.WriteString(string(rune('a' + r.Intn(4))))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestDiff(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		a, b := lines(randomText(r)), lines(randomText(r))
+		edits := diff(a, b, len(a)+len(b))
+		var gotA, gotB []string
+		changes := 0
+		for _, e := range edits {
+			if e.a != len(gotA) || e.b != len(gotB) {
+				t.Fatalf("%q -> %q: edit %+v at %d, %d", a, b, e, len(gotA), len(gotB))
+			}
+			if e.op != insert {
+				gotA = append(gotA, e.text)
+			}
+			if e.op != delete {
+				gotB = append(gotB, e.text)
+			}
+			if e.op != equal {
+				changes++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("%q -> %q: edits give %q -> %q", a, b, gotA, gotB)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("%q -> %q: %d changes; want %d", a, b, changes, want)
+		}
+	}
+}
+
+func TestUnifiedTooManyChanges(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < MaxChanges; i++ {
+		a.WriteString("a\n")
+		b.WriteString("b\n")
+	}
+	if _, err := Unified("A", "B", a.String(), b.String(), 3); err != ErrTooManyChanges {
+		t.Errorf("Unified of texts differing in %d lines: err = %v; want ErrTooManyChanges", 2*MaxChanges, err)
+	}
+	if _, err := Unified("A", "B", a.String()[:MaxChanges], b.String()[:MaxChanges], 3); err != nil {
+		t.Errorf("Unified of texts differing in %d lines: err = %v", MaxChanges, err)
+	}
+}
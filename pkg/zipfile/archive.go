@@ -0,0 +1,541 @@
+// Package zipfile reads files from archives in S3 (or any other store that
+// can serve byte ranges) without downloading the whole archive: only the
+// archive's directory and the bytes of the entries that are read are
+// fetched.
+//
+// Open an archive and read an entry:
+//
+//	a, err := zipfile.Open(ctx, zipfile.S3("my-bucket", "archive.zip"))
+//	if err != nil {
+//		return err
+//	}
+//	rc, err := a.Open("path/to/plan.txt")
+//
+// or extract several to a directory:
+//
+//	err = a.Extract(ctx, zipfile.Match("reports/"), zipfile.DirSink("out"))
+//
+// The package does not log; all failures are returned as errors.
+package zipfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alec-rabold/zipspy/pkg/aws"
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Archive is a remote archive opened with Open. Only its directory is read
+// when it is opened; entries are fetched with range requests as they are
+// read. An Archive is safe for concurrent use.
+type Archive struct {
+	x           *FileExtractor
+	entries     []*Entry
+	concurrency int // entries Extract fetches at once
+}
+
+// Entry describes a file in an Archive.
+type Entry struct {
+	reader.FileHeader
+	file *reader.File
+}
+
+// HasCRC reports whether the entry's CRC32 is known. It is for zip
+// entries, but not for the files of stargz archives or seekable zstd files.
+func (e *Entry) HasCRC() bool {
+	return e.Method != methodStargz && e.Method != methodSeekableZstd
+}
+
+// Source is where an archive's bytes are read from.
+type Source interface {
+	// Size returns the size of the archive in bytes.
+	Size(ctx context.Context) (int64, error)
+	// ReadRange returns a reader over bytes [start, end] (inclusive) of
+	// the archive, which the caller must close.
+	ReadRange(ctx context.Context, start, end int64) (io.ReadCloser, error)
+}
+
+// S3 returns a Source for the archive stored in an S3 bucket under key,
+// which may name an archive nested in others, as in
+// bundle.zip!/service-a.zip (or, failing that, an object whose key holds
+// NestedSeparator). The S3 client is the one given to Open with
+// WithS3Client, or else one configured from the environment.
+//
+// Used directly, the Source reads the outermost object.
+func S3(bucket, key string) Source {
+	return &s3Source{bucket: bucket, key: key}
+}
+
+// S3Version is like S3, but reads the given version of the object in a
+// bucket with versioning enabled.
+func S3Version(bucket, key, version string) Source {
+	return &s3Source{bucket: bucket, key: key, version: version}
+}
+
+// ReaderAt returns a Source that reads an archive of the given size from r,
+// such as an *os.File.
+func ReaderAt(r io.ReaderAt, size int64) Source {
+	return &readerAtSource{r: r, size: size}
+}
+
+// Option configures Open.
+type Option func(*options)
+
+type options struct {
+	client      *aws.Client
+	segments    []string
+	progress    Progress
+	concurrency int
+}
+
+// WithS3Client sets the S3 client used by an S3 Source.
+func WithS3Client(api s3iface.S3API) Option {
+	return func(o *options) {
+		o.client = aws.NewClientWithS3(api)
+	}
+}
+
+// WithClient is like WithS3Client, but takes an aws.Client, with its
+// requester pays and SSE-C settings, so that one client (and the
+// credentials it resolved) can be shared by many archives.
+func WithClient(c *aws.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithSegments gives the keys of the segments of a split S3 archive that
+// precede the key passed to S3, in order. Without it they are looked up as
+// SegmentKeys names them, if the archive turns out to be split.
+func WithSegments(keys ...string) Option {
+	return func(o *options) {
+		o.segments = keys
+	}
+}
+
+// WithProgress sets a Progress to report to as the archive is read and
+// entries are extracted.
+func WithProgress(p Progress) Option {
+	return func(o *options) {
+		o.progress = p
+	}
+}
+
+// WithConcurrency makes Extract fetch up to n of the chosen entries at once,
+// ahead of the one being passed to the Sink. The Sink is still given one
+// entry at a time, in directory order, but Progress.Fetched may be called
+// from several goroutines at once.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// Open reads the directory of the archive in src. Zip archives (including
+// split ones and ones with data prepended), stargz and eStargz archives and
+// seekable zstd files are recognized.
+//
+// ctx is used for every request made for the archive, unless replaced
+// with WithContext.
+func Open(ctx context.Context, src Source, opts ...Option) (*Archive, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var x *FileExtractor
+	if s, ok := src.(*s3Source); ok {
+		client := o.client
+		if client == nil {
+			client = aws.NewClient()
+		}
+		x = &FileExtractor{aws: client, ctx: ctx, bucket: s.bucket, version: s.version, stats: &statsRecorder{}}
+		path, err := x.initNested(s.key)
+		if err != nil {
+			return nil, err
+		}
+		s.setOuter(x.src.(*s3Object))
+		if len(o.segments) > 0 {
+			if err := x.setSegments(o.segments); err != nil {
+				return nil, err
+			}
+		}
+		if path != "" {
+			if x, _, err = x.Resolve(path + NestedSeparator); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		size, err := src.Size(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stats := &statsRecorder{}
+		stats.objectSize(size)
+		x = &FileExtractor{
+			ctx:     ctx,
+			size:    size,
+			src:     &userSource{src, stats},
+			fileMap: make(map[string][]*File),
+			stats:   stats,
+		}
+	}
+	x.progress = o.progress
+	zFiles, err := x.Files()
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{x: x, entries: make([]*Entry, len(zFiles)), concurrency: o.concurrency}
+	for i, f := range zFiles {
+		a.entries[i] = &Entry{FileHeader: f.FileHeader, file: f}
+	}
+	return a, nil
+}
+
+// WithContext returns a copy of a whose reads use ctx instead of the context
+// passed to Open.
+func (a *Archive) WithContext(ctx context.Context) *Archive {
+	return &Archive{x: a.x.WithContext(ctx), entries: a.entries, concurrency: a.concurrency}
+}
+
+// Files returns the archive's entries, in directory order.
+func (a *Archive) Files() []*Entry {
+	return a.entries
+}
+
+// Open returns a reader over the decompressed contents of the entry with
+// exactly the given name. Entries of nested archives are named as
+// service-a.zip!/config/app.yaml.
+func (a *Archive) Open(name string) (io.ReadCloser, error) {
+	return a.OpenRange(name, ByteRange{End: -1})
+}
+
+// OpenRange is like Open, but reads only the given range of the entry's
+// contents, fetching as little of the archive as its format allows.
+func (a *Archive) OpenRange(name string, r ByteRange) (io.ReadCloser, error) {
+	x, name, err := a.x.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := x.Entry(name)
+	if err != nil {
+		return nil, err
+	}
+	return x.OpenRange(file, r)
+}
+
+// Stats returns the requests made and bytes transferred so far for the
+// archive, including its directory.
+func (a *Archive) Stats() Stats {
+	return a.x.Stats()
+}
+
+// FS returns the archive as an io/fs file system.
+func (a *Archive) FS() (*FS, error) {
+	return newFS(a.x)
+}
+
+// Selector chooses the entries to extract. A nil Selector chooses them all.
+type Selector func(e *Entry) bool
+
+// Match returns a Selector for the entries whose names contain any of the
+// given strings, as the CLI's extract -f flag does.
+func Match(terms ...string) Selector {
+	return func(e *Entry) bool {
+		return contains(terms, e.Name) != nil
+	}
+}
+
+// Names returns a Selector for the entries with exactly the given names.
+func Names(names ...string) Selector {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return func(e *Entry) bool {
+		return set[e.Name]
+	}
+}
+
+// Sink receives extracted entries.
+type Sink interface {
+	// Put is called for each extracted entry with its decompressed
+	// contents, which can only be read until Put returns.
+	Put(e *Entry, r io.Reader) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(e *Entry, r io.Reader) error
+
+// Put calls f(e, r).
+func (f SinkFunc) Put(e *Entry, r io.Reader) error {
+	return f(e, r)
+}
+
+// DirSink returns a Sink that writes entries as files below dir, creating
+// directories as needed. Entries whose names would escape dir are rejected,
+// and entries other than directories and regular files are skipped.
+func DirSink(dir string) Sink {
+	return SinkFunc(func(e *Entry, r io.Reader) error {
+		name := path.Clean("/" + e.Name)
+		if name == "/" || name != "/"+strings.TrimSuffix(e.Name, "/") {
+			return fmt.Errorf("zipfile: unsafe entry name %q", e.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := e.Mode()
+		switch {
+		case mode.IsDir():
+			return os.MkdirAll(target, 0755)
+		case !mode.IsRegular():
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		perm := mode.Perm()
+		if perm == 0 {
+			// No permissions were recorded, rather than none granted.
+			perm = 0644
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm|0200)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// Extract reads the entries chosen by sel, in directory order, and passes
+// each to sink. It stops at the first error, or when ctx is done. A Sink
+// that returns before reading all of an entry stops it being decompressed
+// any further.
+func (a *Archive) Extract(ctx context.Context, sel Selector, sink Sink) error {
+	return a.each(ctx, a.choose(sel), (*Archive).open, func(e *Entry, rc io.ReadCloser) error {
+		return a.put(e, rc, sink)
+	})
+}
+
+// choose returns the entries chosen by sel, reporting them to the Progress
+// as planned.
+func (a *Archive) choose(sel Selector) []*Entry {
+	var chosen []*Entry
+	var files []*reader.File
+	for _, e := range a.entries {
+		if sel == nil || sel(e) {
+			chosen = append(chosen, e)
+			files = append(files, e.file)
+		}
+	}
+	a.x.planned(files)
+	return chosen
+}
+
+// each opens the chosen entries with open, using a copy of a whose reads
+// use ctx, and passes them to use, one at a time and in order. use must
+// close the reader. With WithConcurrency, up to a.concurrency entries are
+// opened at once. each stops at the first error, or when ctx is done.
+func (a *Archive) each(ctx context.Context, chosen []*Entry, open func(*Archive, *Entry) (io.ReadCloser, error), use func(*Entry, io.ReadCloser) error) error {
+	if a.concurrency > 1 {
+		return a.eachAhead(ctx, chosen, open, use)
+	}
+	ca := a.WithContext(ctx)
+	for _, e := range chosen {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rc, err := open(ca, e)
+		if err == nil {
+			err = use(e, rc)
+		} else {
+			a.x.completed(e.file, err)
+		}
+		if err != nil {
+			return fmt.Errorf("zipfile: extracting %s: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// eachAhead is each for an Archive opened WithConcurrency, opening up to
+// a.concurrency entries at once while passing them to use in order.
+func (a *Archive) eachAhead(ctx context.Context, chosen []*Entry, open func(*Archive, *Entry) (io.ReadCloser, error), use func(*Entry, io.ReadCloser) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	ca := a.WithContext(ctx)
+	type opened struct {
+		rc  io.ReadCloser
+		err error
+	}
+	results := make([]chan opened, len(chosen))
+	for i := range results {
+		results[i] = make(chan opened, 1)
+	}
+	sem := make(chan struct{}, a.concurrency)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, e := range chosen {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func(i int, e *Entry) {
+				defer wg.Done()
+				rc, err := open(ca, e)
+				results[i] <- opened{rc, err}
+			}(i, e)
+		}
+	}()
+
+	next := 0
+	defer func() {
+		// Close the entries opened but never used, once the opens in
+		// flight have given up.
+		cancel()
+		go func() {
+			wg.Wait()
+			for _, ch := range results[next:] {
+				select {
+				case o := <-ch:
+					if o.rc != nil {
+						o.rc.Close()
+					}
+				default:
+				}
+			}
+		}()
+	}()
+	for ; next < len(chosen); next++ {
+		e := chosen[next]
+		var o opened
+		select {
+		case o = <-results[next]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err := o.err
+		if err == nil {
+			err = use(e, o.rc)
+		} else {
+			a.x.completed(e.file, err)
+		}
+		<-sem
+		if err != nil {
+			return fmt.Errorf("zipfile: extracting %s: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// open fetches an entry, returning a reader over its contents.
+func (a *Archive) open(e *Entry) (io.ReadCloser, error) {
+	if e.Mode().IsDir() {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	return a.x.openFile(e.file)
+}
+
+// put passes an entry opened with open to sink, then closes it.
+func (a *Archive) put(e *Entry, rc io.ReadCloser, sink Sink) (err error) {
+	defer func() { a.x.completed(e.file, err) }()
+	defer rc.Close()
+	return sink.Put(e, a.x.decompressing(e.file, rc))
+}
+
+// s3Source is the Source returned by S3.
+type s3Source struct {
+	bucket  string
+	key     string
+	version string
+
+	mu  sync.Mutex
+	obj *s3Object // the outermost object, once found
+}
+
+// outer returns the outermost object of the archive, which Open sets when
+// it finds it, with the client it was given. Used without Open, the Source
+// finds it with a client configured from the environment.
+func (s *s3Source) outer(ctx context.Context) (*s3Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.obj == nil {
+		x := &FileExtractor{aws: aws.NewClient(), ctx: ctx, bucket: s.bucket, version: s.version}
+		if _, err := x.initNested(s.key); err != nil {
+			return nil, err
+		}
+		s.obj = x.src.(*s3Object)
+	}
+	return s.obj, nil
+}
+
+func (s *s3Source) setOuter(o *s3Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.obj = o
+}
+
+func (s *s3Source) Size(ctx context.Context) (int64, error) {
+	o, err := s.outer(ctx)
+	if err != nil {
+		return 0, err
+	}
+	head, err := o.aws.GetHeadObjectVersion(ctx, o.bucket, o.key, o.version)
+	if err != nil {
+		return 0, err
+	}
+	if head.ContentLength == nil {
+		return 0, errors.New("zipfile: object size unknown")
+	}
+	return *head.ContentLength, nil
+}
+
+func (s *s3Source) ReadRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	o, err := s.outer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return o.getRange(ctx, start, end)
+}
+
+// readerAtSource is the Source returned by ReaderAt.
+type readerAtSource struct {
+	r    io.ReaderAt
+	size int64
+}
+
+func (s *readerAtSource) Size(ctx context.Context) (int64, error) {
+	return s.size, nil
+}
+
+func (s *readerAtSource) ReadRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(io.NewSectionReader(s.r, start, end-start+1)), nil
+}
+
+// userSource reads an archive from a Source passed to Open.
+type userSource struct {
+	src   Source
+	stats *statsRecorder
+}
+
+func (u *userSource) getRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	body, err := u.src.ReadRange(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return u.stats.get(body), nil
+}
@@ -4,26 +4,39 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
 	"strings"
+	"time"
 
 	"github.com/alec-rabold/zipspy/pkg/aws"
 	"github.com/alec-rabold/zipspy/pkg/reader"
-	log "github.com/sirupsen/logrus"
+	"github.com/alec-rabold/zipspy/pkg/zstdseek"
 )
 
-// FileExtractor extracts & decompresses files from a zip archive in S3
+// FileExtractor extracts & decompresses files from a zip archive in S3. It
+// also reads stargz and eStargz archives and seekable zstd files, which are
+// detected automatically.
 type FileExtractor struct {
-	aws    *aws.Client
-	ctx    context.Context
-	bucket string
-	key    string
-	size   int64
+	aws      *aws.Client
+	ctx      context.Context
+	bucket   string
+	key      string
+	version  string // of the S3 object, empty for the current one
+	etag     string // of the S3 object, if known
+	size     int64
+	modified time.Time           // of the S3 object
+	settings *aws.ObjectSettings // of the S3 object, from its HEAD response
+	src      source              // the archive's bytes
 	reader.DirectoryEnd
-	fileMap map[string][]*File
+	files     []*reader.File // central directory, read on first use
+	fileMap   map[string][]*File
+	byteRange *ByteRange          // part of each entry to extract, nil for all of it
+	stargz    *stargzArchive      // set if the archive is stargz rather than zip
+	zstd      *zstdseek.SeekTable // set if the object is seekable zstd rather than zip
+	err       error               // from init, returned by Files
+	progress  Progress            // receives extraction progress, if set
+	stats     *statsRecorder      // shared with copies and nested archives
 }
 
 // ExtractFilesOutput is the response objection from calling Extract()
@@ -39,43 +52,113 @@ type File struct {
 
 // NewFileExtractor creates a new instance of FileExtractor
 func NewFileExtractor(bucket, key string) *FileExtractor {
+	return NewFileExtractorWithContext(context.Background(), bucket, key)
+}
+
+// NewFileExtractorWithContext is like NewFileExtractor, but the extractor's
+// requests use ctx, so they can be cancelled or given a deadline.
+func NewFileExtractorWithContext(ctx context.Context, bucket, key string) *FileExtractor {
 	x := &FileExtractor{
 		aws:    aws.NewClient(),
-		ctx:    context.Background(),
+		ctx:    ctx,
 		bucket: bucket,
 		key:    key,
+		stats:  &statsRecorder{},
 	}
-	x.init()
+	x.err = x.init()
 	return x
 }
 
+// WithContext returns a copy of x whose requests use ctx. The copy shares
+// the archive's directory, if it has been read.
+func (x *FileExtractor) WithContext(ctx context.Context) *FileExtractor {
+	x2 := *x
+	x2.ctx = ctx
+	x2.fileMap = make(map[string][]*File)
+	return &x2
+}
+
 // init sets the extraction metadata
-func (x *FileExtractor) init() {
-	head := x.aws.GetHeadObject(x.ctx, x.bucket, x.key)
+func (x *FileExtractor) init() error {
+	start := time.Now()
+	head, err := x.aws.GetHeadObjectVersion(x.ctx, x.bucket, x.key, x.version)
+	x.stats.head(start)
+	if err != nil {
+		return err
+	}
 	x.size = *head.ContentLength
+	x.stats.objectSize(x.size)
+	if head.LastModified != nil {
+		x.modified = *head.LastModified
+	}
+	if head.ETag != nil {
+		x.etag = *head.ETag
+	}
+	x.settings = aws.HeadSettings(head)
+	x.src = &s3Object{aws: x.aws, bucket: x.bucket, key: x.key, version: x.version, stats: x.stats}
 	x.fileMap = make(map[string][]*File)
+	return nil
 }
 
 // ExtractFiles retrieves the desired files from S3 (compressed), then
 // returns a slice a decompressed File objevts
 func (x *FileExtractor) ExtractFiles(files []string) (*ExtractFilesOutput, error) {
-	dir, err := x.getEOCDRecord()
+	zFiles, err := x.Files()
 	if err != nil {
 		return nil, err
 	}
 
-	x.DirectoryEnd = dir
-	zFiles, err := x.getLocalDirectoryFiles()
+	_, err = x.extractAndDecompressFiles(zFiles, files)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = x.extractAndDecompressFiles(zFiles, files)
+	return &ExtractFilesOutput{x.fileMap}, nil
+}
+
+// Files returns the entries in the archive's central directory, fetching the
+// EOCD record and the directory itself from S3 the first time it is called.
+func (x *FileExtractor) Files() ([]*reader.File, error) {
+	if x.err != nil {
+		return nil, x.err
+	}
+	if x.files != nil {
+		return x.files, nil
+	}
+	start := time.Now()
+	dir, err := x.getEOCDRecord()
+	x.stats.eocdRead(start)
+	start = time.Now()
+	defer x.stats.directoryRead(start)
+	if err == reader.ErrFormat {
+		// Not a zip; it may be one of the other formats that can be read
+		// with range requests.
+		for _, read := range []func() ([]*reader.File, error){x.readStargz, x.readSeekableZstd} {
+			if zFiles, serr := read(); serr == nil {
+				x.files = zFiles
+				return x.files, nil
+			}
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	return &ExtractFilesOutput{x.fileMap}, nil
+	_, split := x.src.(*splitSource)
+	if dir.DiskNumber > 0 || split {
+		if err := x.locateSegments(&dir); err != nil {
+			return nil, err
+		}
+	}
+	x.DirectoryEnd = dir
+	zFiles, err := x.getLocalDirectoryFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := x.locateFiles(zFiles); err != nil {
+		return nil, err
+	}
+	x.files = zFiles
+	return x.files, nil
 }
 
 // EOCDR stands for End of Central Directory\
@@ -86,9 +169,7 @@ func (x *FileExtractor) getEOCDRecord() (reader.DirectoryEnd, error) {
 		if bLen > x.size {
 			bLen = x.size
 		}
-		byteRange := fmt.Sprintf("bytes=%v-%v", x.size-bLen, x.size)
-		response := x.aws.GetS3ObjectWithRange(x.ctx, x.bucket, x.key, byteRange)
-		bodyBytes, err := ioutil.ReadAll(response.Body)
+		bodyBytes, err := x.readRange(x.size-bLen, x.size)
 		if err != nil {
 			return reader.DirectoryEnd{}, err
 		}
@@ -107,11 +188,9 @@ func (x *FileExtractor) getEOCDRecord() (reader.DirectoryEnd, error) {
 
 func (x *FileExtractor) getLocalDirectoryFiles() ([]*reader.File, error) {
 	var zFiles []*reader.File
-	byteRange := fmt.Sprintf("bytes=%v-%v", x.DirectoryOffset, x.DirectoryEndOffset)
-	response := x.aws.GetS3ObjectWithRange(x.ctx, x.bucket, x.key, byteRange)
-	bodyBytes, err := ioutil.ReadAll(response.Body)
+	bodyBytes, err := x.readRange(int64(x.DirectoryOffset), int64(x.DirectoryEndOffset))
 	if err != nil {
-		log.Error(err)
+		return nil, err
 	}
 
 	r := bytes.NewReader(bodyBytes)
@@ -124,7 +203,7 @@ func (x *FileExtractor) getLocalDirectoryFiles() ([]*reader.File, error) {
 		f := &reader.File{
 			Zip:     new(reader.Reader),
 			Zipr:    r,
-			Zipsize: *response.ContentLength}
+			Zipsize: int64(len(bodyBytes))}
 		err = reader.ReadDirectoryHeader(f, buf)
 		if err == reader.ErrFormat || err == io.ErrUnexpectedEOF {
 			break
@@ -132,6 +211,7 @@ func (x *FileExtractor) getLocalDirectoryFiles() ([]*reader.File, error) {
 		if err != nil {
 			return nil, err
 		}
+		f.HeaderOffset += x.BaseOffset
 		zFiles = append(zFiles, f)
 	}
 	return zFiles, nil
@@ -139,43 +219,115 @@ func (x *FileExtractor) getLocalDirectoryFiles() ([]*reader.File, error) {
 
 func (x *FileExtractor) extractAndDecompressFiles(zFiles []*reader.File, filesToExtract []string) ([]*File, error) {
 	var files []*File
+	var matched []*reader.File
+	var terms []string
 	for _, file := range zFiles {
 		if str := contains(filesToExtract, file.Name); str != nil {
-			// Get S3 object w/ range, then decode
-			rangeEnd := int64(math.Min(float64(file.HeaderOffset+int64(file.CompressedSize64)+200), float64(x.size)))
-			byteRange := fmt.Sprintf("bytes=%v-%v", file.HeaderOffset, rangeEnd)
-			response := x.aws.GetS3ObjectWithRange(x.ctx, x.bucket, x.key, byteRange)
-			bodyBytes, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				return nil, err
-			}
-			fileReader := bytes.NewReader(bodyBytes)
-			file.Zipr = fileReader
-
-			rc, err := file.Open()
-			if err != nil {
-				return nil, err
-			}
-			var buf bytes.Buffer
-			_, err = io.Copy(&buf, rc)
-			if err != nil {
-				return nil, err
-			}
-			rc.Close()
-
-			if _, ok := x.fileMap[*str]; !ok {
-				x.fileMap[*str] = make([]*File, 0)
-			}
+			matched = append(matched, file)
+			terms = append(terms, *str)
+		}
+	}
+	x.planned(matched)
+	for i, file := range matched {
+		rc, err := x.openRange(file)
+		if err != nil {
+			x.completed(file, err)
+			return nil, err
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, x.decompressing(file, rc))
+		rc.Close()
+		x.completed(file, err)
+		if err != nil {
+			return nil, err
+		}
 
-			x.fileMap[*str] = append(x.fileMap[*str], &File{
-				FileHeader: file.FileHeader,
-				Contents:   buf,
-			})
+		str := terms[i]
+		if _, ok := x.fileMap[str]; !ok {
+			x.fileMap[str] = make([]*File, 0)
 		}
+
+		x.fileMap[str] = append(x.fileMap[str], &File{
+			FileHeader: file.FileHeader,
+			Contents:   buf,
+		})
 	}
 	return files, nil
 }
 
+// openFile fetches the local header of a single entry from S3, then
+// returns a reader that decompresses its data as it streams in. Closing the
+// reader before the end abandons the rest of the request.
+func (x *FileExtractor) openFile(file *reader.File) (io.ReadCloser, error) {
+	x.stats.opening()
+	if x.stargz != nil {
+		return x.openStargzFile(file)
+	}
+	if x.zstd != nil {
+		return x.openZstdRange(0, x.zstd.Size)
+	}
+	f, err := x.localHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	data, body, err := x.openData(f)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.OpenData(data)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &ctxReadCloser{x.ctx, &limitedReadCloser{rc, multiCloser{rc, body}}}, nil
+}
+
+// localHeader fetches the local header of a single entry from S3, returning
+// a copy of file whose Zipr reads from it. The length of the name and extra
+// field in the local header is only known once it is read, so the lengths
+// in the directory header are used; if the extra field is longer, the end
+// of it is not fetched, as the header is only needed for its name and the
+// offset of the data.
+func (x *FileExtractor) localHeader(file *reader.File) (*reader.File, error) {
+	n := int64(reader.FileHeaderLen + len(file.Name) + len(file.Extra))
+	b, err := x.readRange(file.HeaderOffset, file.HeaderOffset+n-1)
+	if err != nil {
+		return nil, err
+	}
+	f := *file // copy so concurrent opens of the same entry don't share Zipr
+	f.Zipr = bytes.NewReader(b)
+	return &f, nil
+}
+
+// openData opens a stream of an entry's compressed data, followed by its
+// data descriptor if it has one, given the copy of it returned by
+// localHeader. data reads just the compressed data from body, which the
+// caller must close.
+func (x *FileExtractor) openData(f *reader.File) (data io.Reader, body io.ReadCloser, err error) {
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, nil, err
+	}
+	start := f.HeaderOffset + offset
+	body, err = x.getRange(start, start+int64(f.CompressedSize64)+descriptorLen(f)-1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.LimitReader(body, int64(f.CompressedSize64)), body, nil
+}
+
+// descriptorLen returns the most bytes the file's data descriptor can take,
+// or 0 if it has none.
+func descriptorLen(file *reader.File) int64 {
+	switch {
+	case file.Flags&0x8 == 0:
+		return 0
+	case file.CompressedSize64 >= math.MaxUint32 || file.UncompressedSize64 >= math.MaxUint32:
+		return 24 // signature, CRC-32 and zip64 sizes
+	}
+	return 16
+}
+
 // checks if a string (e) contains any substrings of those in a slice (s)
 // returns the matched string from slice (s)[n]
 func contains(s []string, e string) *string {
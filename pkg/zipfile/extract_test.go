@@ -0,0 +1,97 @@
+package zipfile
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingSource is a Source over an archive in memory that counts the
+// bytes read from it and the readers not yet closed.
+type countingSource struct {
+	b    []byte
+	read int64
+	open int32
+}
+
+func (s *countingSource) Size(ctx context.Context) (int64, error) {
+	return int64(len(s.b)), nil
+}
+
+func (s *countingSource) ReadRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	atomic.AddInt32(&s.open, 1)
+	return &countedBody{s, bytes.NewReader(s.b[start : end+1])}, nil
+}
+
+type countedBody struct {
+	s *countingSource
+	r io.Reader
+}
+
+func (r *countedBody) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.s.read, int64(n))
+	return n, err
+}
+
+func (r *countedBody) Close() error {
+	atomic.AddInt32(&r.s.open, -1)
+	return nil
+}
+
+func TestExtractStreams(t *testing.T) {
+	// Lines of random hex, which compress to about half their size, with
+	// a match on the first.
+	var body strings.Builder
+	body.WriteString("needle\n")
+	for i := 0; i < 16*1024; i++ {
+		body.WriteString(hex.EncodeToString(randomBytes(int64(i), 32)) + "\n")
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(body.String()))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := &countingSource{b: buf.Bytes()}
+	a, err := Open(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := a.Files()[0]
+	afterOpen := atomic.LoadInt64(&src.read)
+
+	errFound := errors.New("found")
+	var atMatch int64
+	err = a.Extract(context.Background(), nil, SinkFunc(func(e *Entry, r io.Reader) error {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			if s.Text() == "needle" {
+				atMatch = atomic.LoadInt64(&src.read) - afterOpen
+				return errFound // stop reading, as grep -m does
+			}
+		}
+		return s.Err()
+	}))
+	if !errors.Is(err, errFound) {
+		t.Fatalf("Extract returned %v; want %v", err, errFound)
+	}
+	if atMatch == 0 || atMatch >= int64(e.CompressedSize64)/2 {
+		t.Errorf("%d bytes read before the first match; want fewer than half of the entry's %d", atMatch, e.CompressedSize64)
+	}
+	if n := atomic.LoadInt32(&src.open); n != 0 {
+		t.Errorf("%d readers left open after Extract stopped", n)
+	}
+}
@@ -0,0 +1,320 @@
+package zipfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// FS exposes a zip archive in S3 as an fs.FS. Only the central directory is
+// read up front; entry bodies are fetched from S3 when a file is opened.
+// Directories that are implied by entry paths but have no entry of their own
+// are synthesized.
+type FS struct {
+	x     *FileExtractor
+	nodes map[string]*fsNode // cleaned path -> node, "." is the root
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+)
+
+// fsNode is a file or directory in the archive tree.
+type fsNode struct {
+	name     string       // cleaned, slash-separated path
+	file     *reader.File // nil for synthesized directories
+	isDir    bool
+	children []*fsNode // sorted by name, only set for directories
+}
+
+// NewFS reads the central directory of the archive at bucket/key and returns
+// an FS over its entries. The key may name a nested archive, as with
+// OpenFileExtractor.
+func NewFS(bucket, key string) (*FS, error) {
+	return NewFSWithContext(context.Background(), bucket, key)
+}
+
+// NewFSWithContext is like NewFS, but reading the directory uses ctx, as do
+// later reads unless the FS is replaced by one returned by WithContext.
+func NewFSWithContext(ctx context.Context, bucket, key string) (*FS, error) {
+	x, err := OpenFileExtractorWithContext(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return x.FS()
+}
+
+// WithContext returns a copy of fsys whose reads use ctx, such as the
+// context of the HTTP request being served.
+func (fsys *FS) WithContext(ctx context.Context) *FS {
+	return &FS{x: fsys.x.WithContext(ctx), nodes: fsys.nodes}
+}
+
+// FS reads the central directory of the archive and returns an FS over its
+// entries.
+func (x *FileExtractor) FS() (*FS, error) {
+	return newFS(x)
+}
+
+func newFS(x *FileExtractor) (*FS, error) {
+	files, err := x.Files()
+	if err != nil {
+		return nil, err
+	}
+	fsys := &FS{
+		x:     x,
+		nodes: map[string]*fsNode{".": {name: ".", isDir: true}},
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(path.Clean("/"+f.Name), "/")
+		if name == "" {
+			continue
+		}
+		name = name[1:]
+		n := fsys.node(name, strings.HasSuffix(f.Name, "/"))
+		// Later entries with the same name shadow earlier ones, matching
+		// the behaviour of most unzip implementations.
+		n.file = f
+	}
+	for _, n := range fsys.nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+	return fsys, nil
+}
+
+// node returns the node for name, creating it and any missing parent
+// directories.
+func (fsys *FS) node(name string, isDir bool) *fsNode {
+	if n, ok := fsys.nodes[name]; ok {
+		n.isDir = n.isDir || isDir
+		return n
+	}
+	n := &fsNode{name: name, isDir: isDir}
+	fsys.nodes[name] = n
+	parent := fsys.node(path.Dir(name), true)
+	parent.children = append(parent.children, n)
+	return n
+}
+
+func (fsys *FS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := fsys.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// Open implements fs.FS. Opening a regular file fetches its local header
+// from S3. A stored file is then read with range requests for the bytes
+// asked for, and a compressed one is decompressed as its data streams in,
+// so neither is held in memory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir {
+		return &openDir{n: n}, nil
+	}
+	if n.file.Method == reader.Store {
+		sr, err := fsys.x.OpenStored(n.file)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &openStoredFile{openFile{n: n, rc: sr}, sr}, nil
+	}
+	rc, err := fsys.x.openFile(n.file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &openFile{n: n, rc: rc}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(n.children))
+	for i, c := range n.children {
+		entries[i] = c.info()
+	}
+	return entries, nil
+}
+
+// maxReadFileBuffer caps the buffer ReadFile allocates before reading.
+const maxReadFileBuffer = 512 << 10
+
+// ReadFile implements fs.ReadFileFS.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n := fsys.nodes[name]
+	if n.isDir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	// The size comes from the central directory, which may be corrupt, so
+	// it only sizes the buffer up to a point and append grows it from there.
+	size := n.file.UncompressedSize64
+	if size > maxReadFileBuffer {
+		size = maxReadFileBuffer
+	}
+	buf := make([]byte, 0, size+1)
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		m, err := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+m]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stat implements fs.StatFS. It never touches S3.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+// info returns the FileInfo for the node, built from central directory
+// metadata when the node has an entry of its own.
+func (n *fsNode) info() dirInfo {
+	var fi fs.FileInfo
+	if n.file != nil {
+		fi = n.file.FileInfo()
+	}
+	return dirInfo{n: n, fi: fi}
+}
+
+// dirInfo implements fs.FileInfo and fs.DirEntry for a node.
+type dirInfo struct {
+	n  *fsNode
+	fi fs.FileInfo // nil for synthesized directories
+}
+
+func (d dirInfo) Name() string { return path.Base(d.n.name) }
+func (d dirInfo) Size() int64 {
+	if d.fi == nil || d.n.isDir {
+		return 0
+	}
+	return d.fi.Size()
+}
+func (d dirInfo) Mode() fs.FileMode {
+	if d.fi == nil {
+		return fs.ModeDir | 0555
+	}
+	mode := d.fi.Mode()
+	if d.n.isDir {
+		mode |= fs.ModeDir
+	}
+	if mode.Perm() == 0 {
+		if d.n.isDir {
+			mode |= 0555
+		} else {
+			mode |= 0444
+		}
+	}
+	return mode
+}
+func (d dirInfo) Type() fs.FileMode { return d.Mode().Type() }
+func (d dirInfo) ModTime() time.Time {
+	if d.fi == nil {
+		return time.Time{}
+	}
+	return d.fi.ModTime()
+}
+func (d dirInfo) IsDir() bool      { return d.n.isDir }
+func (d dirInfo) Sys() interface{} { return d.n.file }
+
+func (d dirInfo) Info() (fs.FileInfo, error) { return d, nil }
+
+// openFile is a regular file opened from an FS.
+type openFile struct {
+	n  *fsNode
+	rc io.ReadCloser
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return f.n.info(), nil }
+func (f *openFile) Read(b []byte) (int, error) { return f.rc.Read(b) }
+func (f *openFile) Close() error               { return f.rc.Close() }
+
+// openStoredFile is a stored (uncompressed) file opened from an FS. Unlike
+// compressed entries it supports Seek and ReadAt, reading only the requested
+// bytes from S3, so it can serve ranges.
+type openStoredFile struct {
+	openFile
+	sr *StoredReader
+}
+
+func (f *openStoredFile) Read(b []byte) (int, error) { return f.sr.Read(b) }
+
+func (f *openStoredFile) Seek(offset int64, whence int) (int64, error) {
+	return f.sr.Seek(offset, whence)
+}
+
+func (f *openStoredFile) ReadAt(b []byte, off int64) (int, error) {
+	return f.sr.ReadAt(b, off)
+}
+
+// openDir is a directory opened from an FS.
+type openDir struct {
+	n      *fsNode
+	offset int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.n.info(), nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.n.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *openDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.n.children[d.offset:]
+	if count > 0 && len(rest) > count {
+		rest = rest[:count]
+	}
+	if len(rest) == 0 {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return []fs.DirEntry{}, nil
+	}
+	entries := make([]fs.DirEntry, len(rest))
+	for i, c := range rest {
+		entries[i] = c.info()
+	}
+	d.offset += len(rest)
+	return entries, nil
+}
@@ -0,0 +1,43 @@
+package zipfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	// Entries whose parent directories, but for docs/, have no entries of
+	// their own.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name   string
+		method uint16
+		body   string
+	}{
+		{"README.md", zip.Deflate, "# readme\n"},
+		{"docs/", zip.Store, ""},
+		{"docs/guide.txt", zip.Store, "stored guide\n"},
+		{"src/cmd/main.go", zip.Deflate, "package main\n"},
+		{"src/pkg/util/util.go", zip.Deflate, "package util\n"},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := openBytes(t, buf.Bytes()).FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "README.md", "docs/guide.txt", "src/cmd/main.go", "src/pkg/util/util.go", "src/pkg"); err != nil {
+		t.Fatal(err)
+	}
+}
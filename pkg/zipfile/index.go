@@ -0,0 +1,118 @@
+package zipfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/alec-rabold/zipspy/pkg/flateindex"
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// ErrIndexMismatch indicates an index that was built for different data
+var ErrIndexMismatch = errors.New("zipfile: index does not match entry")
+
+// BuildIndex streams a Deflate entry's compressed data from S3 and builds a
+// checkpoint index for it with a checkpoint every span bytes of uncompressed
+// data. The entry is fetched and decompressed in full once.
+func (x *FileExtractor) BuildIndex(file *reader.File, span int64) (*flateindex.Index, error) {
+	if file.Method != reader.Deflate {
+		return nil, reader.ErrAlgorithm
+	}
+	base, err := x.dataOffset(file)
+	if err != nil {
+		return nil, err
+	}
+	body, err := x.getRange(base, base+int64(file.CompressedSize64)-1)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	idx, err := flateindex.Build(body, span)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIndex(file, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// OpenRangeIndexed is like OpenRange for Deflate entries, but resumes
+// decompression from the checkpoint in idx nearest to the start of the
+// range, fetching only the compressed bytes between it and the end of the
+// range.
+func (x *FileExtractor) OpenRangeIndexed(file *reader.File, r ByteRange, idx *flateindex.Index) (io.ReadCloser, error) {
+	if file.Method != reader.Deflate {
+		return nil, reader.ErrAlgorithm
+	}
+	if err := checkIndex(file, idx); err != nil {
+		return nil, err
+	}
+	off, n, err := r.resolve(int64(file.UncompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+	base, err := x.dataOffset(file)
+	if err != nil {
+		return nil, err
+	}
+	x.stats.opening()
+	cp, start, end := idx.Locate(off, n)
+	body, err := x.getRange(base+start, base+end-1)
+	if err != nil {
+		return nil, err
+	}
+	fr, err := flateindex.NewReader(body, cp)
+	if err == nil {
+		_, err = io.CopyN(ioutil.Discard, fr, off-cp.Out)
+	}
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return x.stats.body(&limitedReadCloser{io.LimitReader(fr, n), body}), nil
+}
+
+// IndexKey returns the key of the sidecar S3 object that SaveIndex and
+// LoadIndex use for an entry's index.
+func (x *FileExtractor) IndexKey(file *reader.File) string {
+	return x.key + ".zidx/" + file.Name
+}
+
+// SaveIndex uploads an index as a sidecar object next to the archive.
+func (x *FileExtractor) SaveIndex(file *reader.File, idx *flateindex.Index) error {
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		return err
+	}
+	key := x.IndexKey(file)
+	_, err := x.aws.PutS3Object(x.ctx, x.bucket, key, bytes.NewReader(buf.Bytes()))
+	return err
+}
+
+// LoadIndex downloads an entry's sidecar index saved by SaveIndex.
+func (x *FileExtractor) LoadIndex(file *reader.File) (*flateindex.Index, error) {
+	key := x.IndexKey(file)
+	response, err := x.aws.GetS3Object(x.ctx, x.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	idx, err := flateindex.ReadIndex(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	return idx, checkIndex(file, idx)
+}
+
+// checkIndex verifies that idx was built for file's data.
+func checkIndex(file *reader.File, idx *flateindex.Index) error {
+	if idx.CRC32 != file.CRC32 ||
+		idx.UncompressedSize != int64(file.UncompressedSize64) ||
+		idx.CompressedSize > int64(file.CompressedSize64) {
+		return ErrIndexMismatch
+	}
+	return nil
+}
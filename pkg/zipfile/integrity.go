@@ -0,0 +1,138 @@
+package zipfile
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// EntryError is a problem Test found with an entry.
+type EntryError struct {
+	Name   string
+	Offset int64 // of the entry's local header (or first chunk) in the archive
+	Err    error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%s (offset %d): %v", e.Name, e.Offset, e.Err)
+}
+
+// Unwrap returns the problem, such as reader.ErrChecksum or an error
+// wrapping reader.ErrLocalHeader.
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// Test reads the entries chosen by sel, in directory order, decompressing
+// them without writing them anywhere and checking each against the
+// archive's directory: for zips, that its local header agrees with its
+// directory header and that its contents match their CRC-32, and for every
+// format, that its contents have the expected size.
+//
+// report is called for each entry with nil or an *EntryError describing
+// what is wrong with it. Test itself only fails if the archive can't be
+// read, as when a request fails or ctx is done.
+func (a *Archive) Test(ctx context.Context, sel Selector, report func(e *Entry, err error)) error {
+	return a.each(ctx, a.choose(sel), (*Archive).openTested, func(e *Entry, rc io.ReadCloser) error {
+		defer rc.Close()
+		t := rc.(*testedReader)
+		problem := t.err
+		if problem == nil {
+			h := crc32.NewIEEE()
+			n, err := io.Copy(h, a.x.decompressing(e.file, t.rc))
+			switch {
+			case err != nil && ctx.Err() != nil:
+				a.x.completed(e.file, err)
+				return err
+			case err != nil:
+				problem = err
+			case uint64(n) != e.UncompressedSize64:
+				problem = fmt.Errorf("%d bytes, directory says %d", n, e.UncompressedSize64)
+			case e.HasCRC() && h.Sum32() != e.CRC32:
+				problem = fmt.Errorf("%w: CRC-32 %08x, directory says %08x", reader.ErrChecksum, h.Sum32(), e.CRC32)
+			default:
+				problem = t.checkDescriptor()
+			}
+		}
+		if problem != nil {
+			problem = &EntryError{Name: e.Name, Offset: e.file.HeaderOffset, Err: problem}
+		}
+		a.x.completed(e.file, problem)
+		report(e, problem)
+		return nil
+	})
+}
+
+// testedReader is an entry opened by openTested, with the problem found
+// before reading it, if any.
+type testedReader struct {
+	rc  io.ReadCloser
+	err error
+
+	// For zip entries, the entry and the stream of its compressed data
+	// and data descriptor.
+	f    *reader.File
+	data io.Reader
+	body io.ReadCloser
+}
+
+func (t *testedReader) Read(p []byte) (int, error) { return t.rc.Read(p) }
+
+// checkDescriptor checks a zip entry's data descriptor, if it has one,
+// once its data has been read.
+func (t *testedReader) checkDescriptor() error {
+	if t.f == nil {
+		return nil
+	}
+	if _, err := io.Copy(ioutil.Discard, t.data); err != nil {
+		return err
+	}
+	return t.f.CheckDataDescriptor(t.body)
+}
+
+func (t *testedReader) Close() error {
+	var err error
+	if t.rc != nil {
+		err = t.rc.Close()
+	}
+	if t.body != nil {
+		if berr := t.body.Close(); err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
+// openTested is like open, but also fetches the local headers of zip
+// directory entries and checks every zip entry's local header; its data
+// descriptor is checked once it has been read. Only failures to fetch an
+// entry are returned as errors.
+func (a *Archive) openTested(e *Entry) (io.ReadCloser, error) {
+	x := a.x
+	if x.stargz != nil || x.zstd != nil {
+		rc, err := a.open(e)
+		if err != nil {
+			return nil, err
+		}
+		return &testedReader{rc: rc}, nil
+	}
+	x.stats.opening()
+	f, err := x.localHeader(e.file)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.CheckLocalHeader(); err != nil {
+		return &testedReader{err: err}, nil
+	}
+	data, body, err := x.openData(f)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.OpenData(data)
+	if err != nil {
+		return &testedReader{err: err, body: body}, nil
+	}
+	return &testedReader{rc: &ctxReadCloser{x.ctx, rc}, f: f, data: data, body: body}, nil
+}
@@ -0,0 +1,135 @@
+package zipfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// longName is an entry name longer than the slack allowed for local headers
+// when entries are fetched.
+var longName = strings.Repeat("deeply/nested/", 20) + "long.txt"
+
+// testEntries are the entries of the archive made by testZip.
+var testEntries = []struct {
+	name   string
+	method uint16
+	extra  []byte
+	body   string
+}{
+	{"short.txt", zip.Deflate, nil, strings.Repeat("compressible ", 100)},
+	{longName, zip.Deflate, nil, "in a deep directory\n"},
+	{"stored.bin", zip.Store, nil, "stored as it is"},
+	// An extra field of an unknown type, which readers skip.
+	{"extra.txt", zip.Deflate, append([]byte{0xfe, 0xca, 0xfc, 0x00}, make([]byte, 252)...), "has a long extra field\n"},
+	{"dir/", zip.Store, nil, ""},
+}
+
+// testZip returns an archive written by archive/zip, which follows every
+// file with a data descriptor.
+func testZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, te := range testEntries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: te.name, Method: te.method, Extra: te.extra})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(te.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openBytes(t *testing.T, b []byte) *Archive {
+	a, err := Open(context.Background(), ReaderAt(bytes.NewReader(b), int64(len(b))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// testArchive returns the problems Test reports, by entry name.
+func testArchive(t *testing.T, b []byte) map[string]error {
+	problems := make(map[string]error)
+	err := openBytes(t, b).Test(context.Background(), nil, func(e *Entry, err error) {
+		if err != nil {
+			problems[e.Name] = err
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return problems
+}
+
+func TestTest(t *testing.T) {
+	data := testZip(t)
+	if problems := testArchive(t, data); len(problems) > 0 {
+		t.Fatalf("Test of a valid archive reported %v", problems)
+	}
+
+	entries := make(map[string]*Entry)
+	offsets := make(map[string]int64)
+	for _, e := range openBytes(t, data).Files() {
+		entries[e.Name] = e
+		offsets[e.Name] = e.file.HeaderOffset
+	}
+	dataOffset := func(name string) int64 {
+		e := entries[name]
+		f := *e.file
+		f.Zipr = bytes.NewReader(data[e.file.HeaderOffset:])
+		off, err := f.DataOffset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.file.HeaderOffset + off
+	}
+
+	tests := []struct {
+		name   string
+		entry  string
+		offset int64 // of the byte to corrupt
+		want   error
+	}{
+		{"local name", "short.txt", offsets["short.txt"] + 30, reader.ErrLocalHeader},
+		{"local method", "stored.bin", offsets["stored.bin"] + 8, reader.ErrLocalHeader},
+		{"stored contents", "stored.bin", dataOffset("stored.bin"), reader.ErrChecksum},
+		{"data descriptor", longName, dataOffset(longName) + int64(entries[longName].CompressedSize64) + 4, reader.ErrLocalHeader},
+	}
+	for _, tt := range tests {
+		bad := append([]byte(nil), data...)
+		bad[tt.offset] ^= 0xff
+		problems := testArchive(t, bad)
+		var ee *EntryError
+		if err := problems[tt.entry]; !errors.As(err, &ee) || !errors.Is(err, tt.want) || ee.Offset != offsets[tt.entry] {
+			t.Errorf("%s: Test reported %v for %s; want an EntryError at %d wrapping %v", tt.name, err, tt.entry, offsets[tt.entry], tt.want)
+		}
+		if len(problems) != 1 {
+			t.Errorf("%s: Test reported %d problems: %v", tt.name, len(problems), problems)
+		}
+	}
+}
+
+func TestOpenLongLocalHeader(t *testing.T) {
+	a := openBytes(t, testZip(t))
+	for _, te := range testEntries {
+		rc, err := a.Open(te.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(b) != te.body {
+			t.Errorf("reading %s: got %q, %v; want %q", te.name, b, err, te.body)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package zipfile
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/aws"
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// NestedSeparator separates the archives in a path to an entry of a nested
+// archive, as in bundle.zip!/service-a.zip!/config/app.yaml.
+const NestedSeparator = "!/"
+
+// OpenFileExtractor is like NewFileExtractor, but key may name an archive
+// nested inside others, such as bundle.zip!/service-a.zip. A key holding
+// NestedSeparator is taken literally if no object is named by the part of
+// it before a separator.
+func OpenFileExtractor(bucket, key string) (*FileExtractor, error) {
+	return OpenFileExtractorWithContext(context.Background(), bucket, key)
+}
+
+// OpenFileExtractorWithContext is like OpenFileExtractor, but the
+// extractor's requests use ctx.
+func OpenFileExtractorWithContext(ctx context.Context, bucket, key string) (*FileExtractor, error) {
+	x := &FileExtractor{aws: aws.NewClient(), ctx: ctx, bucket: bucket, stats: &statsRecorder{}}
+	path, err := x.initNested(key)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return x, nil
+	}
+	x, _, err = x.Resolve(path + NestedSeparator)
+	return x, err
+}
+
+// initNested initializes x for the S3 object that key starts with, and
+// returns the path of the nested archive that follows it, if any. key is
+// split at the first NestedSeparator before which it names an object, or
+// else taken literally, so that objects whose keys hold the separator can
+// still be read. Access denied counts as no object while probing, since
+// it is what S3 answers for missing keys to callers without s3:ListBucket.
+func (x *FileExtractor) initNested(key string) (string, error) {
+	var notFound error
+	for i := 0; ; i += len(NestedSeparator) {
+		j := strings.Index(key[i:], NestedSeparator)
+		if j < 0 {
+			break
+		}
+		i += j
+		x.key = key[:i]
+		err := x.init()
+		if err == nil {
+			return key[i+len(NestedSeparator):], nil
+		}
+		if !missing(err) {
+			return "", err
+		}
+		if notFound == nil {
+			notFound = err
+		}
+	}
+	x.key = key
+	err := x.init()
+	if notFound != nil && missing(err) {
+		// Report the object the key was most likely meant to name.
+		err = notFound
+	}
+	return "", err
+}
+
+// missing reports whether err may mean that an object doesn't exist.
+func missing(err error) bool {
+	return aws.IsNotFound(err) || aws.IsAccessDenied(err)
+}
+
+// Entry returns the entry with exactly the given name.
+func (x *FileExtractor) Entry(name string) (*reader.File, error) {
+	zFiles, err := x.Files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zFiles {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: x.key + NestedSeparator + name, Err: fs.ErrNotExist}
+}
+
+// Resolve follows a path through nested archives. Every element of path
+// but the last names an archive inside the previous one; Resolve returns the
+// innermost of these archives and the last element, which is empty when
+// path ends with NestedSeparator.
+func (x *FileExtractor) Resolve(path string) (*FileExtractor, string, error) {
+	parts := strings.Split(path, NestedSeparator)
+	for _, name := range parts[:len(parts)-1] {
+		file, err := x.Entry(name)
+		if err != nil {
+			return nil, "", err
+		}
+		if x, err = x.OpenArchive(file); err != nil {
+			return nil, "", err
+		}
+	}
+	return x, parts[len(parts)-1], nil
+}
+
+// OpenArchive returns a FileExtractor for a zip archive stored as an entry
+// of this one. If the entry is stored, the inner archive's EOCD record,
+// central directory and entries are all read with range requests into this
+// archive. A compressed entry has to be decompressed into memory first.
+func (x *FileExtractor) OpenArchive(file *reader.File) (*FileExtractor, error) {
+	inner := &FileExtractor{
+		aws:       x.aws,
+		ctx:       x.ctx,
+		bucket:    x.bucket,
+		key:       x.key + NestedSeparator + file.Name,
+		size:      int64(file.UncompressedSize64),
+		fileMap:   make(map[string][]*File),
+		byteRange: x.byteRange,
+		stats:     x.stats,
+	}
+	if file.Method == reader.Store {
+		base, err := x.dataOffset(file)
+		if err != nil {
+			return nil, err
+		}
+		inner.src = &sectionSource{src: x.src, off: base}
+		return inner, nil
+	}
+	rc, err := x.openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	inner.src = memSource(b)
+	return inner, nil
+}
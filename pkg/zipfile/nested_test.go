@@ -0,0 +1,29 @@
+package zipfile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alec-rabold/zipspy/pkg/aws"
+)
+
+func TestOpenLiteralKey(t *testing.T) {
+	for _, denied := range []bool{false, true} {
+		m := newMemS3()
+		m.denied = denied
+		m.objects["logs!/old.zip"] = &memObject{data: updateZip(t, 1)}
+		a, err := Open(context.Background(), S3("bucket", "logs!/old.zip"), WithS3Client(m))
+		if err != nil {
+			t.Errorf("denied %v: Open of a key holding the separator: %v", denied, err)
+			continue
+		}
+		if n := len(a.Files()); n != 1 {
+			t.Errorf("denied %v: archive has %d entries; want 1", denied, n)
+		}
+
+		_, err = Open(context.Background(), S3("bucket", "missing!/old.zip"), WithS3Client(m))
+		if aws.IsNotFound(err) == denied || aws.IsAccessDenied(err) != denied {
+			t.Errorf("denied %v: Open of a missing key returned %v; want S3's answer for it", denied, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package zipfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/alec-rabold/zipspy/pkg/aws"
+)
+
+// source provides the bytes of an archive.
+type source interface {
+	// getRange returns bytes [start, end] (inclusive) of the archive. The
+	// caller must close the returned reader.
+	getRange(ctx context.Context, start, end int64) (io.ReadCloser, error)
+}
+
+// s3Object is an archive stored as a whole S3 object.
+type s3Object struct {
+	aws     *aws.Client
+	bucket  string
+	key     string
+	version string // empty for the current version
+	stats   *statsRecorder
+}
+
+func (o *s3Object) getRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	return o.getRangeIfMatch(ctx, start, end, "")
+}
+
+// getRangeIfMatch is like getRange, but if etag is not empty, it fails
+// with aws.ErrSourceChanged unless the object still has that ETag.
+func (o *s3Object) getRangeIfMatch(ctx context.Context, start, end int64, etag string) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%v-%v", start, end)
+	response, err := o.aws.GetS3ObjectVersionWithRangeIfMatch(ctx, o.bucket, o.key, o.version, etag, byteRange)
+	if err != nil {
+		return nil, err
+	}
+	return o.stats.get(response.Body), nil
+}
+
+// sectionSource is an archive stored uncompressed inside another archive,
+// starting at off.
+type sectionSource struct {
+	src source
+	off int64
+}
+
+func (s *sectionSource) getRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	return s.src.getRange(ctx, s.off+start, s.off+end)
+}
+
+// memSource is an archive held in memory.
+type memSource []byte
+
+func (m memSource) getRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(m[start : end+1])), nil
+}
+
+// splitSource is an archive split into segments stored as separate S3
+// objects, addressed as if the segments were concatenated.
+type splitSource struct {
+	parts  []source
+	starts []int64 // offset of each part; the last element is the total size
+}
+
+func (s *splitSource) getRange(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	var readers []io.Reader
+	var closers multiCloser
+	for i, part := range s.parts {
+		// the requested range, relative to and clamped to this part
+		pstart, pend := start-s.starts[i], end-s.starts[i]
+		if pstart < 0 {
+			pstart = 0
+		}
+		if size := s.starts[i+1] - s.starts[i]; pend >= size {
+			pend = size - 1
+		}
+		if pstart > pend {
+			continue
+		}
+		body, err := part.getRange(ctx, pstart, pend)
+		if err != nil {
+			closers.Close()
+			return nil, err
+		}
+		readers = append(readers, body)
+		closers = append(closers, body)
+	}
+	return &limitedReadCloser{io.MultiReader(readers...), closers}, nil
+}
+
+// multiCloser closes each of its closers in turn, returning the first error.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// getRange fetches bytes [start, end] (inclusive) of the archive and
+// returns them as a stream, which fails once x.ctx is done. The caller must
+// close it.
+func (x *FileExtractor) getRange(start, end int64) (io.ReadCloser, error) {
+	if end >= x.size {
+		end = x.size - 1
+	}
+	if start > end {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	body, err := x.src.getRange(x.ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if x.progress != nil {
+		body = &fetchCounter{body, x.progress}
+	}
+	return &ctxReadCloser{x.ctx, body}, nil
+}
+
+// ctxReadCloser fails reads once its context is done, so that reading and
+// decompressing from any source can be cancelled.
+type ctxReadCloser struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *ctxReadCloser) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// readRange fetches bytes [start, end] (inclusive) of the archive into
+// memory.
+func (x *FileExtractor) readRange(start, end int64) ([]byte, error) {
+	body, err := x.getRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// objectReaderAt is an io.ReaderAt over the archive, issuing one ranged
+// GET per call.
+type objectReaderAt struct {
+	x *FileExtractor
+}
+
+func (o objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.x.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := off + int64(len(p))
+	if end > o.x.size {
+		end = o.x.size
+	}
+	body, err := o.x.getRange(off, end-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:end-off])
+	if err == nil && end-off < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,153 @@
+package zipfile
+
+import (
+	"sort"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// Span is a range of bytes [Start, End] (inclusive) of an archive.
+type Span struct {
+	Start, End int64
+}
+
+// Size returns the number of bytes in the span.
+func (s Span) Size() int64 { return s.End - s.Start + 1 }
+
+// PlannedEntry is an entry that an extraction would read, with the ranges
+// of the archive that would be requested for it.
+type PlannedEntry struct {
+	*reader.File
+	Fetches []Span
+}
+
+// Plan describes the requests an extraction would make. Offsets are in the
+// archive: for a split archive, in its segments placed end to end, and for
+// a nested archive, in the innermost archive.
+type Plan struct {
+	Entries  []*PlannedEntry
+	Ranges   []Span // the entries' fetches merged where they overlap or touch
+	Requests int    // number of GETs
+	Bytes    int64  // bytes requested, counting overlapping fetches each time
+}
+
+// Plan returns the entries ExtractFiles would extract for the given search
+// terms and the ranges it would fetch, honouring the range set with
+// SetRange. Only the archive's directory is read.
+//
+// For zip entries, the offset of the data is estimated from the central
+// directory, since finding it exactly means fetching the local header.
+func (x *FileExtractor) Plan(files []string) (*Plan, error) {
+	zFiles, err := x.Files()
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{}
+	var all []Span
+	for _, file := range zFiles {
+		if contains(files, file.Name) == nil {
+			continue
+		}
+		e := &PlannedEntry{File: file, Fetches: x.plannedFetches(file)}
+		for _, f := range e.Fetches {
+			p.Requests++
+			p.Bytes += f.Size()
+		}
+		all = append(all, e.Fetches...)
+		p.Entries = append(p.Entries, e)
+	}
+	p.Ranges = mergeSpans(all)
+	return p, nil
+}
+
+// plannedFetches returns the ranges openRange would fetch for file.
+func (x *FileExtractor) plannedFetches(file *reader.File) []Span {
+	off, n := int64(0), int64(file.UncompressedSize64)
+	if x.byteRange != nil {
+		var err error
+		if off, n, err = x.byteRange.resolve(n); err != nil {
+			return nil
+		}
+	}
+	var spans []Span
+	switch {
+	case x.stargz != nil:
+		if _, ok := x.stargz.links[file.Name]; ok {
+			return nil
+		}
+		for _, c := range x.stargz.chunks[file.Name] {
+			if n == 0 || x.byteRange == nil || c.ChunkOffset+c.ChunkSize > off && c.ChunkOffset < off+n {
+				spans = append(spans, Span{c.Offset, c.End - 1})
+			}
+		}
+	case x.zstd != nil:
+		frames := x.zstd.Locate(off, n)
+		if len(frames) > 0 {
+			first, last := frames[0], frames[len(frames)-1]
+			spans = append(spans, Span{first.CompressedOffset, last.CompressedOffset + last.CompressedSize - 1})
+		}
+	case file.Method == reader.Store && x.byteRange != nil:
+		// the local header, then the requested bytes
+		spans = append(spans, Span{file.HeaderOffset, file.HeaderOffset + reader.FileHeaderLen - 1})
+		if n > 0 {
+			base := file.HeaderOffset + reader.FileHeaderLen + int64(len(file.Name)+len(file.Extra))
+			spans = append(spans, Span{base + off, base + off + n - 1})
+		}
+	default:
+		// as localHeader, then the data and data descriptor as openData
+		base := file.HeaderOffset + reader.FileHeaderLen + int64(len(file.Name)+len(file.Extra))
+		spans = append(spans, Span{file.HeaderOffset, base - 1})
+		end := base + int64(file.CompressedSize64) + descriptorLen(file) - 1
+		if end >= x.size {
+			end = x.size - 1
+		}
+		if base <= end {
+			spans = append(spans, Span{base, end})
+		}
+	}
+	if split, ok := x.src.(*splitSource); ok {
+		return split.spans(spans)
+	}
+	return spans
+}
+
+// spans divides spans at the boundaries between segments, as getRange
+// does, since each part needs its own request.
+func (s *splitSource) spans(spans []Span) []Span {
+	var out []Span
+	for _, sp := range spans {
+		for i := range s.parts {
+			start, end := sp.Start, sp.End
+			if start < s.starts[i] {
+				start = s.starts[i]
+			}
+			if end >= s.starts[i+1] {
+				end = s.starts[i+1] - 1
+			}
+			if start <= end {
+				out = append(out, Span{start, end})
+			}
+		}
+	}
+	return out
+}
+
+// mergeSpans returns the union of spans, sorted, with spans that overlap or
+// touch joined together.
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+	sorted := append([]Span{}, spans...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	merged := []Span{sorted[0]}
+	for _, s := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if s.Start > last.End+1 {
+			merged = append(merged, s)
+		} else if s.End > last.End {
+			last.End = s.End
+		}
+	}
+	return merged
+}
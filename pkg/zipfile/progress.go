@@ -0,0 +1,103 @@
+package zipfile
+
+import (
+	"io"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// Progress receives reports on an extraction as it runs. Its methods are
+// called from the goroutine doing the extraction and should return quickly.
+type Progress interface {
+	// Planned is called before the first entry is extracted, with the
+	// entries to be extracted and the number of bytes they will produce.
+	Planned(entries []*reader.FileHeader, size int64)
+	// Started is called when an entry that will produce size bytes is
+	// opened.
+	Started(entry *reader.FileHeader, size int64)
+	// Fetched is called as n bytes arrive from the archive's store.
+	Fetched(n int64)
+	// Decompressed is called as n bytes of an entry's contents are read.
+	Decompressed(entry *reader.FileHeader, n int64)
+	// Completed is called when an entry has been extracted, with the error
+	// that stopped it, if any.
+	Completed(entry *reader.FileHeader, err error)
+}
+
+// SetProgress sets the Progress that ExtractFiles reports to.
+func (x *FileExtractor) SetProgress(p Progress) {
+	x.progress = p
+}
+
+// extractSize returns the number of bytes extracting file will produce.
+func (x *FileExtractor) extractSize(file *reader.File) int64 {
+	size := int64(file.UncompressedSize64)
+	if x.byteRange == nil {
+		return size
+	}
+	_, n, err := x.byteRange.resolve(size)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (x *FileExtractor) planned(files []*reader.File) {
+	if x.progress == nil {
+		return
+	}
+	headers := make([]*reader.FileHeader, len(files))
+	var size int64
+	for i, f := range files {
+		headers[i] = &f.FileHeader
+		size += x.extractSize(f)
+	}
+	x.progress.Planned(headers, size)
+}
+
+// decompressing reports file as started and returns r, which reports the
+// bytes read from it.
+func (x *FileExtractor) decompressing(file *reader.File, r io.Reader) io.Reader {
+	if x.stats != nil {
+		r = &countingReader{r, x.stats.decompressed}
+	}
+	if x.progress == nil {
+		return r
+	}
+	x.progress.Started(&file.FileHeader, x.extractSize(file))
+	return &countingReader{r, func(n int64) { x.progress.Decompressed(&file.FileHeader, n) }}
+}
+
+func (x *FileExtractor) completed(file *reader.File, err error) {
+	if x.progress != nil {
+		x.progress.Completed(&file.FileHeader, err)
+	}
+}
+
+// countingReader reports the number of bytes of each read.
+type countingReader struct {
+	r      io.Reader
+	report func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.report(int64(n))
+	}
+	return n, err
+}
+
+// fetchCounter reports the bytes read from a source's stream as fetched.
+type fetchCounter struct {
+	io.ReadCloser
+	p Progress
+}
+
+func (f *fetchCounter) Read(b []byte) (int, error) {
+	n, err := f.ReadCloser.Read(b)
+	if n > 0 {
+		f.p.Fetched(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,117 @@
+package zipfile
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// NewSplitFileExtractor creates a FileExtractor for a split (spanned)
+// archive whose segments are stored as the given keys, in order. The last
+// key holds the central directory, as in data.z01, data.z02, data.zip.
+//
+// A split archive opened with NewFileExtractor using the key of its last
+// segment is handled too; the other segments are then expected to be its
+// siblings, named as SegmentKeys returns.
+func NewSplitFileExtractor(bucket string, keys []string) (*FileExtractor, error) {
+	return NewSplitFileExtractorWithContext(context.Background(), bucket, keys)
+}
+
+// NewSplitFileExtractorWithContext is like NewSplitFileExtractor, but the
+// extractor's requests use ctx.
+func NewSplitFileExtractorWithContext(ctx context.Context, bucket string, keys []string) (*FileExtractor, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no segments given for split archive in bucket %s", bucket)
+	}
+	x := NewFileExtractorWithContext(ctx, bucket, keys[len(keys)-1])
+	if x.err != nil {
+		return nil, x.err
+	}
+	if err := x.setSegments(keys[:len(keys)-1]); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// SegmentKeys returns the conventional keys of the first n segments of the
+// split archive whose last segment is key: data.z01, data.z02, ... for
+// data.zip.
+func SegmentKeys(key string, n int) []string {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s.z%02d", base, i+1)
+	}
+	return keys
+}
+
+// setSegments makes the archive's source the concatenation of the objects
+// with the given keys followed by the archive's own object, which must be
+// the last segment.
+func (x *FileExtractor) setSegments(keys []string) error {
+	split := &splitSource{}
+	var size int64
+	for _, key := range keys {
+		start := time.Now()
+		head, err := x.aws.GetHeadObject(x.ctx, x.bucket, key)
+		x.stats.head(start)
+		if err != nil {
+			return err
+		}
+		x.stats.objectSize(*head.ContentLength)
+		split.parts = append(split.parts, &s3Object{aws: x.aws, bucket: x.bucket, key: key, stats: x.stats})
+		split.starts = append(split.starts, size)
+		size += *head.ContentLength
+	}
+	split.parts = append(split.parts, x.src)
+	split.starts = append(split.starts, size, size+x.size)
+	x.src = split
+	x.size += size
+	return nil
+}
+
+// locateSegments maps the directory offsets of a split archive, which are
+// relative to the segment (disk) holding them, to offsets in the
+// concatenated segments. If the archive was opened with only its last
+// segment, the others are looked up first.
+func (x *FileExtractor) locateSegments(dir *reader.DirectoryEnd) error {
+	split, ok := x.src.(*splitSource)
+	if !ok && x.aws == nil {
+		return fmt.Errorf("zip: archive is split into %d segments, which can only be found in S3", dir.DiskNumber+1)
+	}
+	if !ok {
+		last := x.size
+		if err := x.setSegments(SegmentKeys(x.key, int(dir.DiskNumber))); err != nil {
+			return err
+		}
+		split = x.src.(*splitSource)
+		// the EOCD record was found relative to the last segment alone
+		dir.DirectoryEndOffset += uint64(x.size - last)
+	}
+	if int(dir.DiskNumber) != len(split.parts)-1 || int(dir.DirectoryDisk) >= len(split.parts) {
+		return fmt.Errorf("zip: split archive s3://%s/%s has %d segments, found %d",
+			x.bucket, x.key, dir.DiskNumber+1, len(split.parts))
+	}
+	dir.DirectoryOffset += uint64(split.starts[dir.DirectoryDisk])
+	return nil
+}
+
+// locateFiles maps the local header offsets of a split archive's entries to
+// offsets in the concatenated segments.
+func (x *FileExtractor) locateFiles(zFiles []*reader.File) error {
+	split, ok := x.src.(*splitSource)
+	if !ok {
+		return nil
+	}
+	for _, f := range zFiles {
+		if int(f.DiskNumber) >= len(split.parts) {
+			return reader.ErrFormat
+		}
+		f.HeaderOffset += split.starts[f.DiskNumber]
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package zipfile
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/alec-rabold/zipspy/pkg/stargz"
+)
+
+// methodStargz is the compression method of entries read from a stargz
+// table of contents. Their contents are one or more gzip members.
+const methodStargz uint16 = 0xffff
+
+// stargzArchive holds what is needed to read files from a stargz archive.
+type stargzArchive struct {
+	chunks map[string][]stargz.Chunk // regular files and hard links
+	links  map[string]string         // symlink name -> target
+}
+
+// readStargz reads the footer and table of contents of a stargz or eStargz
+// archive and returns its entries, described as zip entries.
+func (x *FileExtractor) readStargz() ([]*reader.File, error) {
+	if x.size < stargz.FooterSize {
+		return nil, stargz.ErrFormat
+	}
+	footer, err := x.readRange(x.size-stargz.FooterSize, x.size-1)
+	if err != nil {
+		return nil, err
+	}
+	tocOffset, footerSize, err := stargz.ParseFooter(footer)
+	if err != nil {
+		return nil, err
+	}
+	body, err := x.getRange(tocOffset, x.size-int64(footerSize)-1)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	toc, err := stargz.ReadTOC(body, tocOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	sz := &stargzArchive{chunks: toc.Chunks(), links: make(map[string]string)}
+	var zFiles []*reader.File
+	sizes := make(map[string]int64)
+	for _, e := range toc.Entries {
+		if e.Name == "" || e.Type == "chunk" {
+			continue
+		}
+		f := &reader.File{FileHeader: reader.FileHeader{
+			Name:     e.Name,
+			Method:   methodStargz,
+			Modified: e.ModTime(),
+		}}
+		mode := fs.FileMode(e.Mode & 0777)
+		switch e.Type {
+		case "dir":
+			f.Name += "/"
+			mode |= fs.ModeDir
+		case "reg":
+			sizes[e.Name] = e.Size
+		case "hardlink":
+			sz.chunks[e.Name] = sz.chunks[e.LinkName]
+			sizes[e.Name] = sizes[e.LinkName]
+		case "symlink":
+			mode |= fs.ModeSymlink
+			sz.links[e.Name] = e.LinkName
+			sizes[e.Name] = int64(len(e.LinkName))
+		default:
+			continue // devices and fifos have no contents
+		}
+		f.SetMode(mode)
+		f.UncompressedSize64 = uint64(sizes[e.Name])
+		for i, c := range sz.chunks[e.Name] {
+			if i == 0 {
+				f.HeaderOffset = c.Offset
+			}
+			f.CompressedSize64 += uint64(c.End - c.Offset)
+		}
+		zFiles = append(zFiles, f)
+	}
+	x.stargz = sz
+	return zFiles, nil
+}
+
+// openStargzFile returns a reader over a file in a stargz archive, fetching
+// and decompressing one chunk at a time.
+func (x *FileExtractor) openStargzFile(file *reader.File) (io.ReadCloser, error) {
+	if target, ok := x.stargz.links[file.Name]; ok {
+		return ioutil.NopCloser(strings.NewReader(target)), nil
+	}
+	return &chunkReader{x: x, chunks: x.stargz.chunks[file.Name]}, nil
+}
+
+// openStargzRange returns a reader over n bytes at off in a file in a stargz
+// archive, fetching only the chunks that hold them.
+func (x *FileExtractor) openStargzRange(file *reader.File, off, n int64) (io.ReadCloser, error) {
+	if _, ok := x.stargz.links[file.Name]; ok || n == 0 {
+		rc, err := x.openStargzFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, rc, off); err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &limitedReadCloser{io.LimitReader(rc, n), rc}, nil
+	}
+	var chunks []stargz.Chunk
+	for _, c := range x.stargz.chunks[file.Name] {
+		if c.ChunkOffset+c.ChunkSize > off && c.ChunkOffset < off+n {
+			chunks = append(chunks, c)
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, ErrRange
+	}
+	rc := &chunkReader{x: x, chunks: chunks}
+	if _, err := io.CopyN(ioutil.Discard, rc, off-chunks[0].ChunkOffset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(rc, n), rc}, nil
+}
+
+// chunkReader reads a file's chunks in turn, each with its own ranged GET.
+type chunkReader struct {
+	x      *FileExtractor
+	chunks []stargz.Chunk // chunks not yet opened
+	body   io.ReadCloser  // current chunk's compressed bytes
+	r      io.Reader      // current chunk's contents
+	left   int64          // bytes of the current chunk not yet read
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.r == nil || c.left == 0 {
+		if err := c.Close(); err != nil {
+			return 0, err
+		}
+		if len(c.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if err := c.open(c.chunks[0]); err != nil {
+			return 0, err
+		}
+		c.chunks = c.chunks[1:]
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		if c.left > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (c *chunkReader) open(chunk stargz.Chunk) error {
+	body, err := c.x.getRange(chunk.Offset, chunk.End-1)
+	if err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	zr.Multistream(false)
+	c.body, c.r, c.left = body, zr, chunk.ChunkSize
+	return nil
+}
+
+// Close releases the current chunk's request, if any.
+func (c *chunkReader) Close() error {
+	if c.body == nil {
+		return nil
+	}
+	err := c.body.Close()
+	c.body, c.r = nil, nil
+	return err
+}
@@ -0,0 +1,237 @@
+package zipfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/alec-rabold/zipspy/pkg/stargz"
+)
+
+// stargzWriter writes a stargz archive: a tar stream split into gzip
+// members, with each file chunk starting a new one.
+type stargzWriter struct {
+	buf bytes.Buffer
+	zw  *gzip.Writer
+	tw  *tar.Writer
+	toc stargz.TOC
+}
+
+func newStargzWriter() *stargzWriter {
+	w := &stargzWriter{toc: stargz.TOC{Version: 1}}
+	w.zw = gzip.NewWriter(&w.buf)
+	w.tw = tar.NewWriter(w)
+	return w
+}
+
+// Write writes to the current gzip member, for the tar writer.
+func (w *stargzWriter) Write(p []byte) (int, error) {
+	return w.zw.Write(p)
+}
+
+// member ends the current gzip member and returns the offset of the next.
+func (w *stargzWriter) member(t *testing.T) int64 {
+	if err := w.zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w.zw.Reset(&w.buf)
+	return int64(w.buf.Len())
+}
+
+// add adds an entry, with a regular file's contents split into chunks of at
+// most chunkSize bytes.
+func (w *stargzWriter) add(t *testing.T, e stargz.TOCEntry, body string, chunkSize int) {
+	h := &tar.Header{Name: e.Name, Mode: e.Mode, Linkname: e.LinkName, Size: int64(len(body))}
+	switch e.Type {
+	case "dir":
+		h.Typeflag = tar.TypeDir
+	case "symlink":
+		h.Typeflag = tar.TypeSymlink
+	case "hardlink":
+		h.Typeflag = tar.TypeLink
+	default:
+		h.Typeflag = tar.TypeReg
+		e.Size = int64(len(body))
+	}
+	if err := w.tw.WriteHeader(h); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != "reg" || body == "" {
+		w.toc.Entries = append(w.toc.Entries, &e)
+		return
+	}
+	for off := 0; off < len(body); off += chunkSize {
+		n := len(body) - off
+		if n > chunkSize {
+			n = chunkSize
+		}
+		c := e
+		if off > 0 {
+			c = stargz.TOCEntry{Name: e.Name, Type: "chunk"}
+		}
+		c.Offset, c.ChunkOffset = w.member(t), int64(off)
+		if n < len(body) {
+			c.ChunkSize = int64(n)
+		}
+		w.toc.Entries = append(w.toc.Entries, &c)
+		if _, err := w.tw.Write([]byte(body[off : off+n])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// close writes the table of contents and a footer pointing at it, in the
+// eStargz form if estargz is set.
+func (w *stargzWriter) close(t *testing.T, estargz bool) []byte {
+	if err := w.tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tocOffset := w.member(t)
+	b, err := json.Marshal(&w.toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.tw = tar.NewWriter(w)
+	w.tw.WriteHeader(&tar.Header{Name: stargz.TOCTarName, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(b))})
+	w.tw.Write(b)
+	if err := w.tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	extra := []byte(fmt.Sprintf("%016xSTARGZ", tocOffset))
+	if estargz {
+		sg := []byte{'S', 'G', 0, 0}
+		binary.LittleEndian.PutUint16(sg[2:], uint16(len(extra)))
+		extra = append(sg, extra...)
+	}
+	// The footer is an empty gzip member with the extra field, its data an
+	// empty stored block, as written by the stargz tools; compress/gzip
+	// writes a shorter empty block.
+	w.buf.Write([]byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff})
+	binary.Write(&w.buf, binary.LittleEndian, uint16(len(extra)))
+	w.buf.Write(extra)
+	w.buf.Write([]byte{1, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0})
+	return w.buf.Bytes()
+}
+
+// testStargz returns a stargz archive, or an eStargz one if estargz is set,
+// and the contents of its regular files and links.
+func testStargz(t *testing.T, estargz bool) ([]byte, map[string]string) {
+	big := strings.Repeat("0123456789", 100)
+	w := newStargzWriter()
+	w.add(t, stargz.TOCEntry{Name: "./etc/", Type: "dir", Mode: 0755}, "", 0)
+	w.add(t, stargz.TOCEntry{Name: "./etc/hosts", Type: "reg", Mode: 0644}, "127.0.0.1 localhost\n", 64)
+	w.add(t, stargz.TOCEntry{Name: "./big.txt", Type: "reg", Mode: 0644}, big, 300)
+	w.add(t, stargz.TOCEntry{Name: "./empty", Type: "reg", Mode: 0600}, "", 64)
+	w.add(t, stargz.TOCEntry{Name: "./link", Type: "symlink", Mode: 0777, LinkName: "etc/hosts"}, "", 0)
+	w.add(t, stargz.TOCEntry{Name: "./hosts2", Type: "hardlink", Mode: 0644, LinkName: "./etc/hosts"}, "", 0)
+	return w.close(t, estargz), map[string]string{
+		"etc/hosts": "127.0.0.1 localhost\n",
+		"big.txt":   big,
+		"empty":     "",
+		"link":      "etc/hosts",
+		"hosts2":    "127.0.0.1 localhost\n",
+	}
+}
+
+func TestStargz(t *testing.T) {
+	for _, estargz := range []bool{false, true} {
+		data, contents := testStargz(t, estargz)
+		a := openBytes(t, data)
+
+		modes := map[string]fs.FileMode{
+			"etc/":      fs.ModeDir | 0755,
+			"etc/hosts": 0644,
+			"big.txt":   0644,
+			"empty":     0600,
+			"link":      fs.ModeSymlink | 0777,
+			"hosts2":    0644,
+		}
+		files := a.Files()
+		if len(files) != len(modes) {
+			t.Fatalf("estargz %v: %d entries; want %d", estargz, len(files), len(modes))
+		}
+		for _, e := range files {
+			if mode, ok := modes[e.Name]; !ok || e.Mode() != mode {
+				t.Errorf("estargz %v: entry %q has mode %v; want %v", estargz, e.Name, e.Mode(), mode)
+			}
+			want, ok := contents[e.Name]
+			if !ok {
+				continue
+			}
+			if e.UncompressedSize64 != uint64(len(want)) {
+				t.Errorf("estargz %v: %s has size %d; want %d", estargz, e.Name, e.UncompressedSize64, len(want))
+			}
+			rc, err := a.Open(e.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil || string(b) != want {
+				t.Errorf("estargz %v: Open(%q) read %q, %v; want %q", estargz, e.Name, b, err, want)
+			}
+		}
+
+		// Ranges within and across the 300 byte chunks of big.txt.
+		for _, r := range []ByteRange{{Start: 10, End: 19}, {Start: 295, End: 604}, {Start: 900, End: -1}} {
+			rc, err := a.OpenRange("big.txt", r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			end := r.End + 1
+			if r.End < 0 {
+				end = int64(len(contents["big.txt"]))
+			}
+			if want := contents["big.txt"][r.Start:end]; err != nil || string(b) != want {
+				t.Errorf("estargz %v: OpenRange(%+v) = %q, %v; want %q", estargz, r, b, err, want)
+			}
+		}
+	}
+}
+
+func TestStargzFooter(t *testing.T) {
+	for _, estargz := range []bool{false, true} {
+		data, _ := testStargz(t, estargz)
+		corrupt := func(off int, v byte) []byte {
+			b := append([]byte(nil), data...)
+			b[off] = v
+			return b
+		}
+		size := 47
+		if estargz {
+			size = stargz.FooterSize
+		}
+		footer := len(data) - size
+		marker := len(data) - 13 // "STARGZ" ends the extra field, before the data
+		tests := []struct {
+			name string
+			data []byte
+		}{
+			{"truncated", data[:len(data)-1]},
+			{"bad gzip magic", corrupt(footer, 0)},
+			{"bad extra length", corrupt(footer+10, 21)},
+			{"bad STARGZ marker", corrupt(marker-1, 'X')},
+			{"offset not hex", corrupt(marker-7, 'z')},
+			{"offset past the TOC", corrupt(marker-22, '1')},
+		}
+		for _, tt := range tests {
+			if _, err := Open(context.Background(), ReaderAt(bytes.NewReader(tt.data), int64(len(tt.data)))); err == nil {
+				t.Errorf("estargz %v, %s footer: Open succeeded", estargz, tt.name)
+			}
+		}
+	}
+}
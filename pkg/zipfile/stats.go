@@ -0,0 +1,139 @@
+package zipfile
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// S3 Standard list prices in us-east-1, used to estimate the cost of the
+// requests and transfer counted in Stats.
+const (
+	requestCost  = 0.0004 / 1000    // USD per GET or HEAD request
+	transferCost = 0.09 / (1 << 30) // USD per byte transferred out to the internet
+)
+
+// Stats counts the requests made and bytes transferred while reading an
+// archive.
+type Stats struct {
+	HeadRequests      int64
+	GetRequests       int64
+	BytesFetched      int64 // bytes downloaded from the archive's store
+	BytesDecompressed int64 // bytes of entry contents read
+	ObjectSize        int64 // size of the archive's object, or of all its segments
+
+	// Wall time spent on HEAD requests, finding and reading the EOCD
+	// record, reading the central directory, and fetching and
+	// decompressing entries. Reading the footer and table of contents of a
+	// stargz archive, or the seek table of a seekable zstd file, counts as
+	// reading the directory.
+	HeadTime      time.Duration
+	EOCDTime      time.Duration
+	DirectoryTime time.Duration
+	BodyTime      time.Duration
+}
+
+// Transferred returns the percentage of ObjectSize that was downloaded.
+func (s Stats) Transferred() float64 {
+	if s.ObjectSize == 0 {
+		return 0
+	}
+	return 100 * float64(s.BytesFetched) / float64(s.ObjectSize)
+}
+
+// Cost returns an estimate in USD of what the requests and transfer cost,
+// at S3 Standard prices for transfer out of us-east-1 to the internet.
+// Transfer within a region is free, so from EC2 only the requests count.
+func (s Stats) Cost() float64 {
+	return float64(s.HeadRequests+s.GetRequests)*requestCost + float64(s.BytesFetched)*transferCost
+}
+
+// Stats returns the requests made and bytes transferred so far for the
+// archive, including any archives nested in it that were opened.
+func (x *FileExtractor) Stats() Stats {
+	return x.stats.snapshot()
+}
+
+// statsRecorder accumulates Stats for an extractor, its copies and the
+// extractors of nested archives. A nil statsRecorder records nothing.
+type statsRecorder struct {
+	mu                 sync.Mutex
+	s                  Stats
+	bodyStart, bodyEnd time.Time // first and latest activity on entries
+}
+
+func (r *statsRecorder) add(f func(s *Stats)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	f(&r.s)
+	r.mu.Unlock()
+}
+
+// head counts a HEAD request made at start, whether or not it succeeded.
+func (r *statsRecorder) head(start time.Time) {
+	r.add(func(s *Stats) {
+		s.HeadRequests++
+		s.HeadTime += time.Since(start)
+	})
+}
+
+func (r *statsRecorder) objectSize(n int64) {
+	r.add(func(s *Stats) { s.ObjectSize += n })
+}
+
+func (r *statsRecorder) eocdRead(start time.Time) {
+	r.add(func(s *Stats) { s.EOCDTime += time.Since(start) })
+}
+
+func (r *statsRecorder) directoryRead(start time.Time) {
+	r.add(func(s *Stats) { s.DirectoryTime += time.Since(start) })
+}
+
+// get counts a GET request, and the bytes read from its body as fetched.
+func (r *statsRecorder) get(body io.ReadCloser) io.ReadCloser {
+	if r == nil {
+		return body
+	}
+	r.add(func(s *Stats) { s.GetRequests++ })
+	return &limitedReadCloser{&countingReader{body, func(n int64) {
+		r.add(func(s *Stats) { s.BytesFetched += n })
+	}}, body}
+}
+
+// opening marks the start of work on an entry.
+func (r *statsRecorder) opening() {
+	r.decompressed(0)
+}
+
+// decompressed counts n bytes read from an entry.
+func (r *statsRecorder) decompressed(n int64) {
+	r.add(func(s *Stats) {
+		now := time.Now()
+		if r.bodyStart.IsZero() {
+			r.bodyStart = now
+		}
+		r.bodyEnd = now
+		s.BytesDecompressed += n
+	})
+}
+
+// body returns rc, counting the bytes read from it as decompressed.
+func (r *statsRecorder) body(rc io.ReadCloser) io.ReadCloser {
+	if r == nil {
+		return rc
+	}
+	return &limitedReadCloser{&countingReader{rc, r.decompressed}, rc}
+}
+
+func (r *statsRecorder) snapshot() Stats {
+	if r == nil {
+		return Stats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.s
+	s.BodyTime = r.bodyEnd.Sub(r.bodyStart)
+	return s
+}
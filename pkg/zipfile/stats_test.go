@@ -0,0 +1,21 @@
+package zipfile
+
+import "testing"
+
+func TestStatsCountsProbes(t *testing.T) {
+	// The key is taken literally once no object is named by a part of it
+	// before a separator; the HEAD of each part probed counts.
+	m := newMemS3()
+	m.objects["logs!/2020!/old.zip"] = &memObject{data: updateZip(t, 1)}
+	a := openMem(t, m, "logs!/2020!/old.zip")
+	s := a.Stats()
+	if s.HeadRequests != 3 {
+		t.Errorf("HeadRequests = %d; want 3", s.HeadRequests)
+	}
+	if s.GetRequests == 0 || s.BytesFetched == 0 {
+		t.Errorf("GetRequests = %d, BytesFetched = %d; want the EOCD record and directory counted", s.GetRequests, s.BytesFetched)
+	}
+	if s.ObjectSize != int64(len(m.objects["logs!/2020!/old.zip"].data)) {
+		t.Errorf("ObjectSize = %d; want %d", s.ObjectSize, len(m.objects["logs!/2020!/old.zip"].data))
+	}
+}
@@ -0,0 +1,280 @@
+package zipfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// ErrRange indicates a byte range that lies outside of an entry
+var ErrRange = errors.New("zipfile: byte range not satisfiable")
+
+// ByteRange selects part of an entry's uncompressed contents. Start and End
+// are inclusive offsets, and an End of -1 means the end of the entry. A
+// negative Start selects the last -Start bytes, like an HTTP suffix range.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// ParseByteRange parses a range of the form START-END, START- or -SUFFIX.
+func ParseByteRange(s string) (ByteRange, error) {
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return ByteRange{}, fmt.Errorf("invalid byte range %q, expected START-END", s)
+	}
+	start, end := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	if start == "" {
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 {
+			return ByteRange{}, fmt.Errorf("invalid byte range %q", s)
+		}
+		return ByteRange{Start: -n, End: -1}, nil
+	}
+	r := ByteRange{End: -1}
+	var err error
+	if r.Start, err = strconv.ParseInt(start, 10, 64); err != nil || r.Start < 0 {
+		return ByteRange{}, fmt.Errorf("invalid byte range %q", s)
+	}
+	if end != "" {
+		if r.End, err = strconv.ParseInt(end, 10, 64); err != nil || r.End < r.Start {
+			return ByteRange{}, fmt.Errorf("invalid byte range %q", s)
+		}
+	}
+	return r, nil
+}
+
+// resolve returns the offset and length of the range within an entry of the
+// given size.
+func (r ByteRange) resolve(size int64) (off, n int64, err error) {
+	if r.Start < 0 {
+		off = size + r.Start
+		if off < 0 {
+			off = 0
+		}
+		return off, size - off, nil
+	}
+	if r.Start >= size {
+		if size == 0 {
+			return 0, 0, nil
+		}
+		return 0, 0, ErrRange
+	}
+	end := size - 1
+	if r.End >= 0 && r.End < end {
+		end = r.End
+	}
+	return r.Start, end - r.Start + 1, nil
+}
+
+// SetRange limits the contents returned by ExtractFiles to the given range
+// of each entry.
+func (x *FileExtractor) SetRange(r ByteRange) {
+	x.byteRange = &r
+}
+
+// OpenRange returns a reader over the given range of an entry's
+// uncompressed contents. Stored entries are read with a single ranged GET
+// covering only the requested bytes; compressed entries are streamed from
+// their start and decompressed up to the end of the range, and closing the
+// reader abandons the rest. For stargz archives and seekable zstd files
+// only the chunks or frames holding the range are fetched.
+func (x *FileExtractor) OpenRange(file *reader.File, r ByteRange) (io.ReadCloser, error) {
+	rc, err := x.openRangeAt(file, r)
+	if err != nil {
+		return nil, err
+	}
+	return x.stats.body(rc), nil
+}
+
+func (x *FileExtractor) openRangeAt(file *reader.File, r ByteRange) (io.ReadCloser, error) {
+	x.stats.opening()
+	off, n, err := r.resolve(int64(file.UncompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+	if x.stargz != nil {
+		return x.openStargzRange(file, off, n)
+	}
+	if x.zstd != nil {
+		return x.openZstdRange(off, n)
+	}
+	if file.Method == reader.Store {
+		sr, err := x.OpenStored(file)
+		if err != nil {
+			return nil, err
+		}
+		return sr.rangeReader(off, n)
+	}
+	rc, err := x.openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, rc, off); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(rc, n), rc}, nil
+}
+
+// openRange opens an entry, honouring the range set with SetRange.
+func (x *FileExtractor) openRange(file *reader.File) (io.ReadCloser, error) {
+	if x.byteRange == nil {
+		return x.openFile(file)
+	}
+	return x.openRangeAt(file, *x.byteRange)
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// StoredReader provides random access to a stored (uncompressed) entry
+// without downloading it. Because a stored entry's contents are a
+// contiguous byte range of the archive object, every read maps directly to
+// a ranged GET of just the bytes requested.
+//
+// ReadAt issues one request per call. Read streams from a single request
+// that starts at the current offset and runs to the end of the entry; Seek
+// abandons that request.
+type StoredReader struct {
+	x      *FileExtractor
+	base   int64 // offset of the entry's data in the archive object
+	size   int64
+	offset int64         // current offset for Read and Seek
+	body   io.ReadCloser // open stream at offset, if any
+}
+
+var (
+	_ io.ReaderAt   = (*StoredReader)(nil)
+	_ io.ReadSeeker = (*StoredReader)(nil)
+)
+
+// OpenStored returns a StoredReader for a stored entry. It fetches only the
+// entry's local header, to locate the start of its data.
+func (x *FileExtractor) OpenStored(file *reader.File) (*StoredReader, error) {
+	if file.Method != reader.Store {
+		return nil, reader.ErrAlgorithm
+	}
+	base, err := x.dataOffset(file)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredReader{
+		x:    x,
+		base: base,
+		size: int64(file.UncompressedSize64),
+	}, nil
+}
+
+// dataOffset returns the offset of an entry's (possibly compressed) data in
+// the archive object, fetching only its local header.
+func (x *FileExtractor) dataOffset(file *reader.File) (int64, error) {
+	f := *file
+	f.Zipr = io.NewSectionReader(objectReaderAt{x}, file.HeaderOffset, x.size-file.HeaderOffset)
+	offset, err := f.DataOffset()
+	if err != nil {
+		return 0, err
+	}
+	return file.HeaderOffset + offset, nil
+}
+
+// Size returns the size of the entry in bytes.
+func (r *StoredReader) Size() int64 { return r.size }
+
+// ReadAt implements io.ReaderAt
+func (r *StoredReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("zipfile: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	var err error
+	if max := r.size - off; int64(len(p)) > max {
+		p = p[:max]
+		err = io.EOF
+	}
+	n, rerr := objectReaderAt{r.x}.ReadAt(p, r.base+off)
+	if rerr != nil {
+		err = rerr
+	}
+	return n, err
+}
+
+// Read implements io.Reader
+func (r *StoredReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.x.getRange(r.base+r.offset, r.base+r.size-1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	if max := r.size - r.offset; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (r *StoredReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("zipfile: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("zipfile: negative position")
+	}
+	if offset != r.offset {
+		r.closeBody()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close releases any in-flight request.
+func (r *StoredReader) Close() error {
+	return r.closeBody()
+}
+
+func (r *StoredReader) closeBody() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// rangeReader returns a reader over n bytes at off, fetched with a single
+// ranged GET.
+func (r *StoredReader) rangeReader(off, n int64) (io.ReadCloser, error) {
+	if n == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	body, err := r.x.getRange(r.base+off, r.base+off+n-1)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(body, n), body}, nil
+}
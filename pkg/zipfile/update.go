@@ -0,0 +1,521 @@
+package zipfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+)
+
+// S3's limits on the parts of a multipart upload.
+const (
+	minPartSize = 5 << 20 // of every part but the last
+	maxPartSize = 5 << 30
+	maxParts    = 10000
+
+	uploadPartSize = 16 << 20 // of the parts uploaded, unless there are too many
+)
+
+// Update writes a changed copy of a zip archive to S3 without downloading
+// and uploading it again: the new object is assembled with a multipart
+// upload in which the bytes of the entries kept are copied within S3
+// (UploadPartCopy), and only the added entries and a new central directory
+// are uploaded. The new object keeps the old one's content headers, user
+// metadata, storage class, SSE-S3 or SSE-KMS encryption and tags, but not
+// its ACL or object lock settings. Create one with Archive.Update. An
+// Update is not safe for concurrent use.
+type Update struct {
+	a        *Archive
+	bucket   string // where the new archive is written
+	key      string
+	names    map[string]bool // of the entries in the new archive
+	removed  map[*Entry]bool
+	added    []addedEntry
+	tail     *os.File // local headers and data of the added entries
+	tailSize int64
+	body     *os.File     // compressed data of the entry being written
+	w        *entryWriter // the entry being written, if any
+	err      error        // sticky, from writing an added entry
+}
+
+// addedEntry is an entry added with Create, whose local header is at off in
+// the Update's tail.
+type addedEntry struct {
+	h   reader.FileHeader
+	off int64
+}
+
+// UpdateResult describes the object written by Update.Commit.
+type UpdateResult struct {
+	Size       int64 // of the new archive
+	Parts      int   // of the multipart upload
+	Copied     int64 // bytes copied within S3
+	Uploaded   int64 // bytes uploaded, including those downloaded
+	Downloaded int64 // bytes of the old archive in ranges too small to copy as a part
+	VersionID  string
+}
+
+// Update starts an update of the archive that will be written to the S3
+// object bucket/key: the archive's own, to replace it, or another, leaving
+// it as it is. Only zip archives stored as a whole S3 object (not nested or
+// split) can be updated. The Update must be closed once committed or
+// abandoned, to remove its temporary files.
+func (a *Archive) Update(bucket, key string) (*Update, error) {
+	x := a.x
+	if _, ok := x.src.(*s3Object); !ok || x.stargz != nil || x.zstd != nil || x.DiskNumber > 0 {
+		return nil, errors.New("zipfile: only zip archives stored as a whole S3 object can be updated")
+	}
+	u := &Update{a: a, bucket: bucket, key: key, names: make(map[string]bool), removed: make(map[*Entry]bool)}
+	for _, e := range a.entries {
+		u.names[e.Name] = true
+	}
+	var err error
+	if u.tail, err = ioutil.TempFile("", "zipspy-update-*"); err != nil {
+		return nil, err
+	}
+	if u.body, err = ioutil.TempFile("", "zipspy-update-*"); err != nil {
+		u.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+// Create adds an entry with the header fh to the archive, returning a
+// writer for its contents, which can be used until the next call to Create
+// or Commit. The contents are compressed with the compressor registered for
+// fh.Method (see reader.RegisterCompressor), and the CRC-32 and sizes are
+// computed. reader.FileInfoHeader is a convenient way to make fh. It is an
+// error to add an entry with the name of one in the archive, unless that
+// has been removed.
+func (u *Update) Create(fh *reader.FileHeader) (io.Writer, error) {
+	if err := u.finish(); err != nil {
+		return nil, err
+	}
+	if fh.Name == "" {
+		return nil, errors.New("zipfile: entry name is empty")
+	}
+	if u.names[fh.Name] {
+		return nil, fmt.Errorf("zipfile: %s is already in the archive", fh.Name)
+	}
+	h := *fh
+	h.Flags &^= 0x8 // the sizes go in the local header
+	if requiresUTF8(h.Name) || requiresUTF8(h.Comment) {
+		h.Flags |= 0x800
+	}
+	if h.ReaderVersion < 20 {
+		h.ReaderVersion = 20
+	}
+	if h.Mode().IsDir() {
+		h.Method = reader.Store
+	}
+	if _, err := u.body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := u.body.Truncate(0); err != nil {
+		return nil, err
+	}
+	body := &countWriter{w: u.body}
+	comp, err := reader.NewCompressor(body, h.Method)
+	if err != nil {
+		return nil, err
+	}
+	u.names[h.Name] = true
+	u.w = &entryWriter{h: h, comp: comp, body: body, crc: crc32.NewIEEE()}
+	return u.w, nil
+}
+
+// Remove removes the archive's entries chosen by sel and returns them.
+// Their bytes are left out of the new archive altogether.
+func (u *Update) Remove(sel Selector) []*Entry {
+	var removed []*Entry
+	for _, e := range u.a.entries {
+		if !u.removed[e] && (sel == nil || sel(e)) {
+			u.removed[e] = true
+			delete(u.names, e.Name)
+			removed = append(removed, e)
+		}
+	}
+	return removed
+}
+
+// finish writes the local header and data of the entry being written, if
+// any, to the tail.
+func (u *Update) finish() error {
+	if u.err != nil {
+		return u.err
+	}
+	w := u.w
+	if w == nil {
+		return nil
+	}
+	u.w = nil
+	w.done = true
+	if u.err = w.comp.Close(); u.err != nil {
+		return u.err
+	}
+	if w.h.Mode().IsDir() && w.n > 0 {
+		u.err = fmt.Errorf("zipfile: %s is a directory but has contents", w.h.Name)
+		return u.err
+	}
+	w.h.CRC32 = w.crc.Sum32()
+	w.h.UncompressedSize64 = uint64(w.n)
+	w.h.CompressedSize64 = uint64(w.body.n)
+	tail := &countWriter{w: u.tail}
+	if u.err = reader.WriteFileHeader(tail, &w.h); u.err != nil {
+		return u.err
+	}
+	if _, u.err = io.Copy(tail, io.NewSectionReader(u.body, 0, w.body.n)); u.err != nil {
+		return u.err
+	}
+	u.added = append(u.added, addedEntry{w.h, u.tailSize})
+	u.tailSize += tail.n
+	return nil
+}
+
+// Commit writes the new archive: the entries kept, in order and moved
+// down over the bytes of those removed, followed by the added entries and
+// a central directory listing them all, in zip64 format if it has to be.
+// The bytes of the old archive are copied and downloaded only if it is
+// unchanged since it was opened, failing with aws.ErrSourceChanged
+// otherwise, so an archive is never replaced with a mix of versions. The
+// Archive still reads the old archive afterwards.
+func (u *Update) Commit(ctx context.Context) (*UpdateResult, error) {
+	if err := u.finish(); err != nil {
+		return nil, err
+	}
+	x := u.a.x.WithContext(ctx)
+	pieces, offsets, kept, err := u.layout()
+	if err != nil {
+		return nil, err
+	}
+
+	dirOffset := kept + u.tailSize
+	tail := &countWriter{w: u.tail}
+	bw := bufio.NewWriter(tail)
+	records := int64(len(u.added))
+	for _, e := range u.a.entries {
+		if u.removed[e] {
+			continue
+		}
+		if err := reader.WriteDirectoryHeader(bw, &e.file.FileHeader, offsets[e.file.HeaderOffset]); err != nil {
+			return nil, err
+		}
+		records++
+	}
+	for _, ad := range u.added {
+		if err := reader.WriteDirectoryHeader(bw, &ad.h, kept+ad.off); err != nil {
+			return nil, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := reader.WriteDirectoryEnd(bw, records, tail.n, dirOffset, x.DirectoryEnd.Comment()); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	u.tailSize += tail.n
+	u.err = errors.New("zipfile: Update already committed")
+
+	pieces = append(pieces, piece{off: 0, n: u.tailSize})
+	return u.upload(ctx, x, pieces)
+}
+
+// layout returns the ranges of the archive to copy into the new one, the
+// new offsets of the local headers of the entries kept (by their old ones)
+// and the number of bytes kept. Everything before the central directory is
+// kept but the removed entries, each of which extends from its local header
+// to the next one, taking its data descriptor and any padding with it.
+func (u *Update) layout() (pieces []piece, offsets map[int64]int64, kept int64, err error) {
+	end := int64(u.a.x.DirectoryOffset)
+	keep := make(map[int64]bool) // by local header offset
+	var starts []int64
+	for _, e := range u.a.entries {
+		off := e.file.HeaderOffset
+		if off < 0 || off >= end {
+			return nil, nil, 0, fmt.Errorf("zipfile: %s has a local header offset (%d) outside the archive's entries", e.Name, off)
+		}
+		if _, ok := keep[off]; !ok {
+			starts = append(starts, off)
+		}
+		// entries sharing a local header keep it if either is kept
+		keep[off] = keep[off] || !u.removed[e]
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	add := func(off, n int64) {
+		if l := len(pieces); l > 0 && pieces[l-1].off+pieces[l-1].n == off {
+			pieces[l-1].n += n
+		} else if n > 0 {
+			pieces = append(pieces, piece{off, n, true})
+		}
+		kept += n
+	}
+	offsets = make(map[int64]int64)
+	if len(starts) == 0 {
+		add(0, end)
+		return pieces, offsets, kept, nil
+	}
+	add(0, starts[0])
+	for i, off := range starts {
+		next := end
+		if i+1 < len(starts) {
+			next = starts[i+1]
+		}
+		if keep[off] {
+			offsets[off] = kept
+			add(off, next-off)
+		}
+	}
+	return pieces, offsets, kept, nil
+}
+
+// Close removes the Update's temporary files.
+func (u *Update) Close() error {
+	var err error
+	for _, f := range []*os.File{u.tail, u.body} {
+		if f == nil {
+			continue
+		}
+		f.Close()
+		if rerr := os.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// piece is n bytes of the new archive: copied from off in the old one, or
+// uploaded from off in the tail.
+type piece struct {
+	off, n int64
+	copy   bool
+}
+
+// planParts groups the pieces of a new archive into the parts of a
+// multipart upload. A part is either a single piece copied within S3, or
+// pieces uploaded together, up to partSize bytes; copied pieces in those
+// are downloaded first. As S3 requires, every part but the last is at least
+// minPartSize bytes: a piece to be copied that is smaller is downloaded,
+// as is the start of one following a part that would otherwise be too
+// small.
+func planParts(pieces []piece, partSize int64) [][]piece {
+	var parts [][]piece
+	var buf []piece // the part being filled with pieces to upload
+	var bufN int64
+	flush := func() {
+		parts = append(parts, buf)
+		buf, bufN = nil, 0
+	}
+	add := func(p piece) {
+		for p.n > 0 {
+			n := p.n
+			if n > partSize-bufN {
+				n = partSize - bufN
+			}
+			buf = append(buf, piece{p.off, n, p.copy})
+			bufN += n
+			p.off += n
+			p.n -= n
+			if bufN == partSize {
+				flush()
+			}
+		}
+	}
+	for _, p := range pieces {
+		if !p.copy {
+			add(p)
+			continue
+		}
+		if bufN > 0 {
+			var need int64
+			if bufN < minPartSize {
+				need = minPartSize - bufN
+			}
+			if p.n-need < minPartSize {
+				add(p)
+				continue
+			}
+			add(piece{p.off, need, true})
+			if bufN > 0 {
+				flush()
+			}
+			p.off += need
+			p.n -= need
+		} else if p.n < minPartSize {
+			add(p)
+			continue
+		}
+		for p.n > 0 {
+			n := p.n
+			if n > maxPartSize {
+				n = maxPartSize
+				if p.n-n < minPartSize {
+					n = p.n - minPartSize
+				}
+			}
+			parts = append(parts, []piece{{p.off, n, true}})
+			p.off += n
+			p.n -= n
+		}
+	}
+	if bufN > 0 || len(parts) == 0 {
+		flush()
+	}
+	return parts
+}
+
+// upload writes the new archive made of pieces with a multipart upload,
+// aborting it if anything fails.
+func (u *Update) upload(ctx context.Context, x *FileExtractor, pieces []piece) (*UpdateResult, error) {
+	res := &UpdateResult{}
+	for _, p := range pieces {
+		res.Size += p.n
+	}
+	partSize := int64(uploadPartSize)
+	if n := res.Size / (maxParts / 2); n > partSize {
+		partSize = n
+	}
+	parts := planParts(pieces, partSize)
+	res.Parts = len(parts)
+	if len(parts) > maxParts {
+		// Every run of kept entries long enough is copied as a part of its
+		// own, so removing many entries far apart can leave too many.
+		return nil, fmt.Errorf("zipfile: the new archive would take %d parts to write, more than S3's limit of %d; remove fewer entries at a time", len(parts), maxParts)
+	}
+
+	// The new archive has the old one's settings and tags, which S3 would
+	// otherwise leave behind when it replaces it.
+	o := x.src.(*s3Object)
+	settings := *x.settings
+	tagging, err := x.aws.GetObjectTagging(ctx, o.bucket, o.key, o.version)
+	if err != nil {
+		return nil, fmt.Errorf("zipfile: the archive's tags cannot be read to keep them: %w", err)
+	}
+	settings.Tagging = tagging
+	if settings.ContentType == "" {
+		settings.ContentType = "application/zip"
+	}
+	uploadID, err := x.aws.CreateMultipartUpload(ctx, u.bucket, u.key, &settings)
+	if err != nil {
+		return nil, err
+	}
+	etags := make([]string, len(parts))
+	for i, part := range parts {
+		if etags[i], err = u.uploadPart(ctx, x, uploadID, int64(i+1), part, res); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		out, cerr := x.aws.CompleteMultipartUpload(ctx, u.bucket, u.key, uploadID, etags)
+		if cerr == nil {
+			if out.VersionId != nil {
+				res.VersionID = *out.VersionId
+			}
+			return res, nil
+		}
+		err = cerr
+	}
+	// The context may be done; aborting is worth a try regardless.
+	actx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if aerr := x.aws.AbortMultipartUpload(actx, u.bucket, u.key, uploadID); aerr != nil {
+		return nil, fmt.Errorf("%w (and the incomplete upload %s could not be aborted: %v)", err, uploadID, aerr)
+	}
+	return nil, err
+}
+
+// uploadPart copies or uploads one part of the new archive.
+func (u *Update) uploadPart(ctx context.Context, x *FileExtractor, uploadID string, n int64, part []piece, res *UpdateResult) (string, error) {
+	o := x.src.(*s3Object)
+	if len(part) == 1 && part[0].copy {
+		p := part[0]
+		etag, err := x.aws.UploadPartCopy(ctx, u.bucket, u.key, uploadID, n, o.bucket, o.key, o.version, x.etag, p.off, p.off+p.n-1)
+		if err != nil {
+			return "", err
+		}
+		res.Copied += p.n
+		return etag, nil
+	}
+	var buf bytes.Buffer
+	for _, p := range part {
+		if p.copy {
+			// Like the copies, the downloads fail if the archive changes.
+			body, err := o.getRangeIfMatch(ctx, p.off, p.off+p.n-1, x.etag)
+			if err != nil {
+				return "", err
+			}
+			b, err := ioutil.ReadAll(body)
+			body.Close()
+			if err != nil {
+				return "", err
+			}
+			if int64(len(b)) != p.n {
+				return "", io.ErrUnexpectedEOF
+			}
+			buf.Write(b)
+			res.Downloaded += p.n
+			continue
+		}
+		if _, err := io.Copy(&buf, io.NewSectionReader(u.tail, p.off, p.n)); err != nil {
+			return "", err
+		}
+	}
+	res.Uploaded += int64(buf.Len())
+	return x.aws.UploadPart(ctx, u.bucket, u.key, uploadID, n, bytes.NewReader(buf.Bytes()))
+}
+
+// entryWriter compresses the contents of an entry added with Create.
+type entryWriter struct {
+	h    reader.FileHeader
+	comp io.WriteCloser
+	body *countWriter // counts the compressed bytes
+	crc  hash.Hash32
+	n    int64 // uncompressed bytes written
+	done bool  // set once the entry is written to the tail
+}
+
+func (w *entryWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, fmt.Errorf("zipfile: write to %s after Create or Commit", w.h.Name)
+	}
+	w.crc.Write(p)
+	w.n += int64(len(p))
+	return w.comp.Write(p)
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// requiresUTF8 reports whether s must be marked as UTF-8 in a zip header:
+// it is valid UTF-8 but not all ASCII, as archive/zip decides.
+func requiresUTF8(s string) bool {
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			return utf8.ValidString(s)
+		}
+	}
+	return false
+}
@@ -0,0 +1,809 @@
+package zipfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alec-rabold/zipspy/pkg/aws"
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// memS3 is an S3 bucket held in memory, implementing the requests that
+// Open and Update make. Buckets are ignored: objects are stored by key.
+type memS3 struct {
+	s3iface.S3API
+	mu         sync.Mutex
+	objects    map[string]*memObject
+	uploads    map[string]*memUpload
+	nextID     int
+	tagErr     error // returned by GetObjectTagging, if set
+	denied     bool  // answer for missing keys with 403, as to callers without s3:ListBucket
+	copied     int64 // bytes copied by UploadPartCopy
+	downloaded int64 // bytes returned by GET
+	aborted    int
+}
+
+type memObject struct {
+	data     []byte
+	settings s3.CreateMultipartUploadInput // of the upload that wrote it
+	tags     []*s3.Tag
+}
+
+type memUpload struct {
+	input s3.CreateMultipartUploadInput
+	parts map[int64][]byte
+}
+
+func newMemS3() *memS3 {
+	return &memS3{objects: make(map[string]*memObject), uploads: make(map[string]*memUpload)}
+}
+
+func memETag(b []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(b))
+}
+
+func memError(status int, code string) error {
+	return awserr.NewRequestFailure(awserr.New(code, code, nil), status, "request-id")
+}
+
+// object returns the object with key, or a 404 (or 403) error. It must be
+// called with m.mu held.
+func (m *memS3) object(key string) (*memObject, error) {
+	o, ok := m.objects[key]
+	if !ok && m.denied {
+		return nil, memError(403, "Forbidden")
+	}
+	if !ok {
+		return nil, memError(404, "NotFound")
+	}
+	return o, nil
+}
+
+func (m *memS3) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, err := m.object(*input.Key)
+	if err != nil {
+		return nil, err
+	}
+	st := o.settings
+	var expires *string
+	if st.Expires != nil {
+		expires = awssdk.String(st.Expires.Format(http.TimeFormat))
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:        awssdk.Int64(int64(len(o.data))),
+		ETag:                 awssdk.String(memETag(o.data)),
+		LastModified:         awssdk.Time(time.Unix(0, 0)),
+		ContentType:          st.ContentType,
+		CacheControl:         st.CacheControl,
+		ContentDisposition:   st.ContentDisposition,
+		Expires:              expires,
+		Metadata:             st.Metadata,
+		StorageClass:         st.StorageClass,
+		ServerSideEncryption: st.ServerSideEncryption,
+		SSEKMSKeyId:          st.SSEKMSKeyId,
+	}, nil
+}
+
+func (m *memS3) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, err := m.object(*input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if input.IfMatch != nil && *input.IfMatch != memETag(o.data) {
+		return nil, memError(412, "PreconditionFailed")
+	}
+	start, end := parseRange(awssdk.StringValue(input.Range), int64(len(o.data)))
+	m.downloaded += end + 1 - start
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(o.data[start : end+1]))}, nil
+}
+
+func (m *memS3) GetObjectTaggingWithContext(ctx context.Context, input *s3.GetObjectTaggingInput, opts ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tagErr != nil {
+		return nil, m.tagErr
+	}
+	o, err := m.object(*input.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectTaggingOutput{TagSet: o.tags}, nil
+}
+
+func (m *memS3) CreateMultipartUploadWithContext(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	id := strconv.Itoa(m.nextID)
+	m.uploads[id] = &memUpload{input: *input, parts: make(map[int64][]byte)}
+	return &s3.CreateMultipartUploadOutput{UploadId: &id}, nil
+}
+
+func (m *memS3) UploadPartWithContext(ctx context.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	b, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[*input.UploadId]
+	if !ok {
+		return nil, memError(404, "NoSuchUpload")
+	}
+	u.parts[*input.PartNumber] = b
+	return &s3.UploadPartOutput{ETag: awssdk.String(memETag(b))}, nil
+}
+
+func (m *memS3) UploadPartCopyWithContext(ctx context.Context, input *s3.UploadPartCopyInput, opts ...request.Option) (*s3.UploadPartCopyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[*input.UploadId]
+	if !ok {
+		return nil, memError(404, "NoSuchUpload")
+	}
+	source := *input.CopySource
+	source, err := url.PathUnescape(source[strings.Index(source, "/")+1:])
+	if err != nil {
+		return nil, err
+	}
+	o, err := m.object(source)
+	if err != nil {
+		return nil, err
+	}
+	if input.CopySourceIfMatch != nil && *input.CopySourceIfMatch != memETag(o.data) {
+		return nil, memError(412, "PreconditionFailed")
+	}
+	start, end := parseRange(*input.CopySourceRange, int64(len(o.data)))
+	b := append([]byte(nil), o.data[start:end+1]...)
+	u.parts[*input.PartNumber] = b
+	m.copied += int64(len(b))
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{ETag: awssdk.String(memETag(b))}}, nil
+}
+
+func (m *memS3) CompleteMultipartUploadWithContext(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[*input.UploadId]
+	if !ok {
+		return nil, memError(404, "NoSuchUpload")
+	}
+	parts := input.MultipartUpload.Parts
+	if len(parts) > maxParts {
+		return nil, memError(400, "InvalidArgument")
+	}
+	var data []byte
+	for i, p := range parts {
+		b, ok := u.parts[*p.PartNumber]
+		if !ok || *p.PartNumber != int64(i+1) || *p.ETag != memETag(b) {
+			return nil, memError(400, "InvalidPart")
+		}
+		if i < len(parts)-1 && len(b) < minPartSize || len(b) > maxPartSize {
+			return nil, memError(400, "EntityTooSmall")
+		}
+		data = append(data, b...)
+	}
+	o := &memObject{data: data, settings: u.input}
+	if u.input.Tagging != nil {
+		tags, err := url.ParseQuery(*u.input.Tagging)
+		if err != nil {
+			return nil, memError(400, "InvalidTag")
+		}
+		for k := range tags {
+			o.tags = append(o.tags, &s3.Tag{Key: awssdk.String(k), Value: awssdk.String(tags.Get(k))})
+		}
+	}
+	m.objects[*input.Key] = o
+	delete(m.uploads, *input.UploadId)
+	return &s3.CompleteMultipartUploadOutput{ETag: awssdk.String(memETag(data))}, nil
+}
+
+func (m *memS3) AbortMultipartUploadWithContext(ctx context.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, *input.UploadId)
+	m.aborted++
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// parseRange returns the bounds of a "bytes=start-end" range, clamped to
+// an object of the given size.
+func parseRange(r string, size int64) (start, end int64) {
+	fmt.Sscanf(r, "bytes=%d-%d", &start, &end)
+	if end >= size {
+		end = size - 1
+	}
+	return start, end
+}
+
+// randomBytes returns n bytes that do not compress.
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+// updateEntries are the entries of the archive made by updateZip, with
+// stored ones large enough to be copied as parts of their own.
+var updateEntries = []struct {
+	name   string
+	method uint16
+	body   []byte
+}{
+	{"a.txt", zip.Deflate, []byte(strings.Repeat("first entry\n", 1000))},
+	{"big1.bin", zip.Store, randomBytes(1, 6<<20)},
+	{"b.txt", zip.Deflate, []byte("between the big ones\n")},
+	{"big2.bin", zip.Store, randomBytes(2, 7<<20)},
+	{"dir/", zip.Store, nil},
+	{"c.txt", zip.Store, []byte("last entry\n")},
+}
+
+// updateZip returns an archive of the first n of updateEntries.
+func updateZip(t *testing.T, n int) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range updateEntries[:n] {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.body)
+	}
+	if err := zw.SetComment("kept comment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// markText sets the internal attributes of every entry in an archive made
+// by updateZip to 1, marking them as text, which archive/zip can't do.
+func markText(data []byte) {
+	end := len(data) - 22 - len("kept comment")
+	off := int(binary.LittleEndian.Uint32(data[end+16:]))
+	for binary.LittleEndian.Uint32(data[off:]) == 0x02014b50 {
+		binary.LittleEndian.PutUint16(data[off+36:], 1)
+		off += 46 + int(binary.LittleEndian.Uint16(data[off+28:])) + int(binary.LittleEndian.Uint16(data[off+30:])) + int(binary.LittleEndian.Uint16(data[off+32:]))
+	}
+}
+
+// openMem opens the archive stored in m under key.
+func openMem(t *testing.T, m *memS3, key string) *Archive {
+	a, err := Open(context.Background(), S3("bucket", key), WithS3Client(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// readZip returns the contents of the entries of an archive, which must
+// read back with archive/zip and pass Test, by name.
+func readZip(t *testing.T, data []byte) (map[string]string, string) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("reading the new archive: %v", err)
+	}
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s from the new archive: %v", f.Name, err)
+		}
+		contents[f.Name] = string(b)
+	}
+	if problems := testArchive(t, data); len(problems) > 0 {
+		t.Errorf("Test of the new archive reported %v", problems)
+	}
+	return contents, zr.Comment
+}
+
+// appendEntry adds an entry named name with body to the archive.
+func appendEntry(t *testing.T, u *Update, name string, method uint16, body string) {
+	w, err := u.Create(&reader.FileHeader{Name: name, Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	tests := []struct {
+		name       string
+		n          int  // of updateEntries in the archive
+		copied     bool // whether any of the archive is copied within S3
+		downloaded bool // whether any of the archive is downloaded
+	}{
+		{"small archive", 1, false, true},
+		{"large archive", len(updateEntries), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemS3()
+			old := updateZip(t, tt.n)
+			markText(old)
+			m.objects["old.zip"] = &memObject{data: old}
+			a := openMem(t, m, "old.zip")
+			u, err := a.Update("bucket", "new.zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer u.Close()
+			appendEntry(t, u, "added/one.txt", reader.Deflate, strings.Repeat("added ", 500))
+			appendEntry(t, u, "added/two.txt", reader.Store, "stored")
+			if _, err := u.Create(&reader.FileHeader{Name: "a.txt"}); err == nil {
+				t.Error("Create of an entry the archive has succeeded")
+			}
+			res, err := u.Commit(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := m.objects["new.zip"].data
+			if res.Size != int64(len(data)) || res.Copied != m.copied {
+				t.Errorf("result = %+v for an archive of %d bytes, %d of them copied", res, len(data), m.copied)
+			}
+			if (res.Copied > 0) != tt.copied || (res.Downloaded > 0) != tt.downloaded {
+				t.Errorf("copied %d and downloaded %d bytes; want copied %v, downloaded %v", res.Copied, res.Downloaded, tt.copied, tt.downloaded)
+			}
+			if res.Copied+res.Uploaded != res.Size {
+				t.Errorf("copied %d and uploaded %d bytes of %d", res.Copied, res.Uploaded, res.Size)
+			}
+			contents, comment := readZip(t, data)
+			want := map[string]string{"added/one.txt": strings.Repeat("added ", 500), "added/two.txt": "stored"}
+			for _, e := range updateEntries[:tt.n] {
+				want[e.name] = string(e.body)
+			}
+			if len(contents) != len(want) {
+				t.Errorf("new archive has %d entries; want %d", len(contents), len(want))
+			}
+			for name, body := range want {
+				if got, ok := contents[name]; !ok || got != body {
+					t.Errorf("%s in the new archive: %d bytes (present: %v); want %d", name, len(got), ok, len(body))
+				}
+			}
+			if comment != "kept comment" {
+				t.Errorf("comment = %q; want the old archive's", comment)
+			}
+			for _, e := range openBytes(t, data).Files() {
+				want := uint16(1)
+				if strings.HasPrefix(e.Name, "added/") {
+					want = 0
+				}
+				if e.file.InternalAttrs != want {
+					t.Errorf("%s in the new archive has internal attributes %d; want %d", e.Name, e.file.InternalAttrs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitZip64(t *testing.T) {
+	// archive/zip writes the zip64 records for 65535 entries or more, as
+	// the update must when it adds one more.
+	const n = 1 << 16
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("f%d", i), Method: zip.Store}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m := newMemS3()
+	m.objects["old.zip"] = &memObject{data: buf.Bytes()}
+	u, err := openMem(t, m, "old.zip").Update("bucket", "new.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	appendEntry(t, u, "added.txt", reader.Deflate, "added")
+	if _, err := u.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers tolerate a record count cut to 16 bits, so look at the zip64
+	// end of central directory record, before its locator and the end
+	// record, itself.
+	data := m.objects["new.zip"].data
+	end64 := data[len(data)-22-20-56:]
+	if sig := binary.LittleEndian.Uint32(end64); sig != 0x06064b50 {
+		t.Fatalf("no zip64 end of central directory record: found signature %#x", sig)
+	}
+	if records := binary.LittleEndian.Uint64(end64[32:]); records != n+1 {
+		t.Errorf("zip64 end of central directory record counts %d entries; want %d", records, n+1)
+	}
+
+	contents, _ := readZip(t, data)
+	if len(contents) != n+1 || contents["added.txt"] != "added" {
+		t.Errorf("new archive has %d entries, added.txt %q; want %d and %q", len(contents), contents["added.txt"], n+1, "added")
+	}
+	if got := len(openBytes(t, data).Files()); got != n+1 {
+		t.Errorf("new archive opens with %d entries; want %d", got, n+1)
+	}
+}
+
+func TestCommitKeepsSettings(t *testing.T) {
+	m := newMemS3()
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	settings := s3.CreateMultipartUploadInput{
+		ContentType:          awssdk.String("application/x-zip-compressed"),
+		CacheControl:         awssdk.String("max-age=60"),
+		ContentDisposition:   awssdk.String(`attachment; filename="release.zip"`),
+		Expires:              &expires,
+		Metadata:             map[string]*string{"Build": awssdk.String("1234")},
+		StorageClass:         awssdk.String(s3.StorageClassStandardIa),
+		ServerSideEncryption: awssdk.String(s3.ServerSideEncryptionAwsKms),
+		SSEKMSKeyId:          awssdk.String("arn:aws:kms:us-east-1:111122223333:key/example"),
+	}
+	tags := []*s3.Tag{{Key: awssdk.String("team"), Value: awssdk.String("a&b")}}
+	m.objects["old.zip"] = &memObject{data: updateZip(t, 1), settings: settings, tags: tags}
+	u, err := openMem(t, m, "old.zip").Update("bucket", "new.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	appendEntry(t, u, "added.txt", reader.Deflate, "added")
+	if _, err := u.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := m.objects["new.zip"]
+	st := got.settings
+	for _, f := range []struct {
+		name      string
+		got, want *string
+	}{
+		{"ContentType", st.ContentType, settings.ContentType},
+		{"CacheControl", st.CacheControl, settings.CacheControl},
+		{"ContentDisposition", st.ContentDisposition, settings.ContentDisposition},
+		{"Build metadata", st.Metadata["Build"], settings.Metadata["Build"]},
+		{"StorageClass", st.StorageClass, settings.StorageClass},
+		{"ServerSideEncryption", st.ServerSideEncryption, settings.ServerSideEncryption},
+		{"SSEKMSKeyId", st.SSEKMSKeyId, settings.SSEKMSKeyId},
+	} {
+		if awssdk.StringValue(f.got) != awssdk.StringValue(f.want) {
+			t.Errorf("%s = %q; want %q", f.name, awssdk.StringValue(f.got), awssdk.StringValue(f.want))
+		}
+	}
+	if st.Expires == nil || !st.Expires.Equal(expires) {
+		t.Errorf("Expires = %v; want %v", st.Expires, expires)
+	}
+	if len(got.tags) != 1 || *got.tags[0].Key != "team" || *got.tags[0].Value != "a&b" {
+		t.Errorf("tags = %v; want %v", got.tags, tags)
+	}
+
+	// Without settings, the new archive is still given a content type.
+	m.objects["plain.zip"] = &memObject{data: updateZip(t, 1)}
+	u, err = openMem(t, m, "plain.zip").Update("bucket", "plain.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if _, err := u.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	st = m.objects["plain.zip"].settings
+	if ct := awssdk.StringValue(st.ContentType); ct != "application/zip" || st.Tagging != nil || st.StorageClass != nil {
+		t.Errorf("settings of an archive that had none: content type %q, tagging %v, storage class %v", ct, st.Tagging, st.StorageClass)
+	}
+}
+
+func TestCommitTagsUnreadable(t *testing.T) {
+	m := newMemS3()
+	m.objects["old.zip"] = &memObject{data: updateZip(t, 1)}
+	m.tagErr = memError(403, "AccessDenied")
+	u, err := openMem(t, m, "old.zip").Update("bucket", "old.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if _, err := u.Commit(context.Background()); err == nil {
+		t.Fatal("Commit succeeded without reading the archive's tags")
+	}
+	if m.nextID != 0 {
+		t.Errorf("Commit started %d uploads; want none", m.nextID)
+	}
+}
+
+func TestCommitSourceChanged(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int // of updateEntries in the archive
+	}{
+		{"downloaded", 1},
+		{"copied", len(updateEntries)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemS3()
+			old := updateZip(t, tt.n)
+			m.objects["old.zip"] = &memObject{data: old}
+			u, err := openMem(t, m, "old.zip").Update("bucket", "old.zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer u.Close()
+			appendEntry(t, u, "added.txt", reader.Deflate, "added")
+
+			changed := append([]byte(nil), old...)
+			changed[0] ^= 0xff
+			m.objects["old.zip"] = &memObject{data: changed}
+			_, err = u.Commit(context.Background())
+			if !errors.Is(err, aws.ErrSourceChanged) {
+				t.Errorf("Commit of a changed archive: err = %v; want ErrSourceChanged", err)
+			}
+			if m.aborted != 1 || len(m.uploads) != 0 {
+				t.Errorf("%d uploads aborted, %d left; want the one aborted", m.aborted, len(m.uploads))
+			}
+			if !bytes.Equal(m.objects["old.zip"].data, changed) {
+				t.Error("Commit replaced the changed archive")
+			}
+		})
+	}
+}
+
+// checkParts checks that parts, as planned by planParts for pieces, are
+// within S3's limits and, put together, make up the pieces in order.
+func checkParts(t *testing.T, pieces []piece, partSize int64, parts [][]piece) {
+	t.Helper()
+	var got, want []piece
+	join := func(ps []piece, p piece) []piece {
+		if l := len(ps); l > 0 && ps[l-1].copy == p.copy && ps[l-1].off+ps[l-1].n == p.off {
+			ps[l-1].n += p.n
+			return ps
+		} else if p.n == 0 {
+			return ps
+		}
+		return append(ps, p)
+	}
+	for i, part := range parts {
+		var n int64
+		for _, p := range part {
+			if p.n <= 0 {
+				t.Fatalf("part %d has an empty piece: %v", i, parts)
+			}
+			n += p.n
+			got = join(got, p)
+		}
+		if i < len(parts)-1 && n < minPartSize {
+			t.Fatalf("part %d of %d has %d bytes, less than the minimum, planning %v", i, len(parts), n, pieces)
+		}
+		if len(part) == 1 && part[0].copy {
+			if n > maxPartSize {
+				t.Fatalf("part %d copies %d bytes, more than the maximum", i, n)
+			}
+		} else if n > partSize {
+			t.Fatalf("part %d uploads %d bytes, more than %d", i, n, partSize)
+		}
+	}
+	for _, p := range pieces {
+		want = join(want, p)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("parts make up %v; want %v", got, want)
+	}
+}
+
+func TestPlanParts(t *testing.T) {
+	const partSize = uploadPartSize
+	copied := func(parts [][]piece) (n int) {
+		for _, part := range parts {
+			if len(part) == 1 && part[0].copy {
+				n++
+			}
+		}
+		return n
+	}
+	tests := []struct {
+		name   string
+		pieces []piece
+		parts  int // planned
+		copied int // of the parts, copied within S3
+	}{
+		{"empty archive", []piece{{0, 22, false}}, 1, 0},
+		{"small archive", []piece{{0, 1000, true}, {0, 100, false}}, 1, 0},
+		{"copied then uploaded", []piece{{0, 6 << 20, true}, {0, 100, false}}, 2, 1},
+		{"large upload", []piece{{0, 6 << 20, true}, {0, 40 << 20, false}}, 4, 1},
+		{"short run before a copy", []piece{{0, 6 << 20, true}, {7 << 20, 1000, true}, {8 << 20, 12 << 20, true}, {0, 100, false}}, 4, 2},
+		{"short run before a short copy", []piece{{0, 6 << 20, true}, {7 << 20, 1000, true}, {8 << 20, 6 << 20, true}, {0, 100, false}}, 2, 1},
+		{"copy too large for a part", []piece{{0, maxPartSize + 1, true}, {0, 100, false}}, 3, 2},
+	}
+	for _, tt := range tests {
+		parts := planParts(tt.pieces, partSize)
+		checkParts(t, tt.pieces, partSize, parts)
+		if len(parts) != tt.parts || copied(parts) != tt.copied {
+			t.Errorf("%s: %d parts, %d copied; want %d, %d: %v", tt.name, len(parts), copied(parts), tt.parts, tt.copied, parts)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	sizes := []int64{0, 1, 1000, minPartSize - 1, minPartSize, minPartSize + 1, 2*minPartSize - 1, 3 * minPartSize, maxPartSize - 1, maxPartSize + 1, maxPartSize + minPartSize/2, 2*maxPartSize + 7}
+	for i := 0; i < 5000; i++ {
+		var pieces []piece
+		var off int64
+		for j := rnd.Intn(6); j >= 0; j-- {
+			n := sizes[rnd.Intn(len(sizes))]
+			pieces = append(pieces, piece{off, n, true})
+			off += n + int64(rnd.Intn(100))
+		}
+		pieces = append(pieces, piece{0, 1 + sizes[rnd.Intn(len(sizes))]%(64<<20), false})
+		checkParts(t, pieces, partSize, planParts(pieces, partSize))
+	}
+}
+
+func TestLayout(t *testing.T) {
+	m := newMemS3()
+	m.objects["old.zip"] = &memObject{data: updateZip(t, len(updateEntries))}
+	a := openMem(t, m, "old.zip")
+	var starts []int64 // of the entries' local headers, in order
+	for _, e := range a.Files() {
+		starts = append(starts, e.file.HeaderOffset)
+	}
+	end := int64(a.x.DirectoryOffset)
+	tests := []struct {
+		name    string
+		removed []string
+		pieces  []piece
+	}{
+		{"nothing", nil, []piece{{0, end, true}}},
+		{"first", []string{"a.txt"}, []piece{{starts[1], end - starts[1], true}}},
+		{"middle", []string{"b.txt", "big2.bin"}, []piece{{0, starts[2], true}, {starts[4], end - starts[4], true}}},
+		{"last", []string{"c.txt"}, []piece{{0, starts[5], true}}},
+		{"apart", []string{"a.txt", "b.txt", "c.txt"}, []piece{{starts[1], starts[2] - starts[1], true}, {starts[3], starts[5] - starts[3], true}}},
+		{"all", []string{"a.txt", "big1.bin", "b.txt", "big2.bin", "dir/", "c.txt"}, nil},
+	}
+	for _, tt := range tests {
+		u, err := a.Update("bucket", "old.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed := u.Remove(Names(tt.removed...)); len(removed) != len(tt.removed) {
+			t.Errorf("%s: Remove removed %d entries; want %d", tt.name, len(removed), len(tt.removed))
+		}
+		pieces, offsets, kept, err := u.layout()
+		u.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(pieces) != fmt.Sprint(tt.pieces) {
+			t.Errorf("%s: pieces = %v; want %v", tt.name, pieces, tt.pieces)
+		}
+		var want int64
+		for _, p := range tt.pieces {
+			want += p.n
+		}
+		if kept != want {
+			t.Errorf("%s: kept %d bytes; want %d", tt.name, kept, want)
+		}
+		// The kept entries' local headers are moved down over the removed
+		// ones, in order.
+		var next int64
+		for i, e := range a.Files() {
+			off, ok := offsets[e.file.HeaderOffset]
+			if u.removed[e] {
+				if ok {
+					t.Errorf("%s: removed %s has a new offset", tt.name, e.Name)
+				}
+				continue
+			}
+			if !ok || off != next {
+				t.Errorf("%s: %s moved to %d (%v); want %d", tt.name, e.Name, off, ok, next)
+			}
+			size := end - starts[i]
+			if i+1 < len(starts) {
+				size = starts[i+1] - starts[i]
+			}
+			next = off + size
+		}
+	}
+}
+
+func TestCommitRemove(t *testing.T) {
+	m := newMemS3()
+	m.objects["old.zip"] = &memObject{data: updateZip(t, len(updateEntries))}
+	a := openMem(t, m, "old.zip")
+	u, err := a.Update("bucket", "old.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if removed := u.Remove(Names("a.txt", "big2.bin")); len(removed) != 2 {
+		t.Fatalf("Remove removed %d entries; want 2", len(removed))
+	}
+	// b.txt is replaced, keeping its method, as replace does.
+	removed := u.Remove(Names("b.txt"))
+	if len(removed) != 1 {
+		t.Fatalf("Remove removed %d entries; want 1", len(removed))
+	}
+	appendEntry(t, u, "b.txt", removed[0].Method, "replaced")
+	res, err := u.Commit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Copied == 0 || res.Downloaded == 0 {
+		t.Errorf("copied %d and downloaded %d bytes; want the large entry copied and the short run after it downloaded", res.Copied, res.Downloaded)
+	}
+
+	data := m.objects["old.zip"].data
+	contents, _ := readZip(t, data)
+	want := map[string]string{"b.txt": "replaced"}
+	for _, e := range updateEntries {
+		if e.name != "a.txt" && e.name != "b.txt" && e.name != "big2.bin" {
+			want[e.name] = string(e.body)
+		}
+	}
+	if len(contents) != len(want) {
+		t.Errorf("new archive has %d entries; want %d", len(contents), len(want))
+	}
+	for name, body := range want {
+		if got, ok := contents[name]; !ok || got != body {
+			t.Errorf("%s in the new archive: %d bytes (present: %v); want %d", name, len(got), ok, len(body))
+		}
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name == "b.txt" && f.Method != zip.Deflate {
+			t.Errorf("replaced b.txt has method %d; want Deflate, as before", f.Method)
+		}
+	}
+}
+
+func TestUploadTooManyParts(t *testing.T) {
+	m := newMemS3()
+	m.objects["old.zip"] = &memObject{data: updateZip(t, 1)}
+	a := openMem(t, m, "old.zip")
+	u, err := a.Update("bucket", "old.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	// Runs of kept entries each long enough to be copied as a part, as if
+	// every other one of many large entries were removed.
+	var pieces []piece
+	for i := int64(0); i <= maxParts; i++ {
+		pieces = append(pieces, piece{2 * i * minPartSize, minPartSize, true})
+	}
+	pieces = append(pieces, piece{0, 100, false})
+	if _, err := u.upload(context.Background(), a.x, pieces); err == nil {
+		t.Fatal("upload of more than maxParts parts succeeded")
+	}
+	if m.nextID != 0 {
+		t.Errorf("upload started %d uploads; want none", m.nextID)
+	}
+}
@@ -0,0 +1,85 @@
+package zipfile
+
+import (
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/alec-rabold/zipspy/pkg/reader"
+	"github.com/alec-rabold/zipspy/pkg/zstdseek"
+)
+
+// methodSeekableZstd is the compression method of the single entry of a
+// seekable zstd file.
+const methodSeekableZstd uint16 = 0xfffe
+
+// readSeekableZstd reads the seek table of a seekable zstd file and returns
+// a single entry for its decompressed contents, named after the key without
+// its .zst extension.
+func (x *FileExtractor) readSeekableZstd() ([]*reader.File, error) {
+	if x.size < zstdseek.FooterSize {
+		return nil, zstdseek.ErrFormat
+	}
+	footer, err := x.readRange(x.size-zstdseek.FooterSize, x.size-1)
+	if err != nil {
+		return nil, err
+	}
+	tableSize, err := zstdseek.ParseFooter(footer)
+	if err != nil {
+		return nil, err
+	}
+	if tableSize > x.size {
+		return nil, zstdseek.ErrFormat
+	}
+	b, err := x.readRange(x.size-tableSize, x.size-1)
+	if err != nil {
+		return nil, err
+	}
+	table, err := zstdseek.ParseSeekTable(b)
+	if err != nil {
+		return nil, err
+	}
+	if table.CompressedSize+table.TableSize != x.size {
+		return nil, zstdseek.ErrFormat
+	}
+	x.zstd = table
+	name := "data" // for sources other than S3, which have no key
+	if x.key != "" {
+		name = strings.TrimSuffix(path.Base(x.key), ".zst")
+	}
+	f := &reader.File{FileHeader: reader.FileHeader{
+		Name:               name,
+		Method:             methodSeekableZstd,
+		Modified:           x.modified,
+		CompressedSize64:   uint64(table.CompressedSize),
+		UncompressedSize64: uint64(table.Size),
+	}}
+	f.SetMode(0644)
+	return []*reader.File{f}, nil
+}
+
+// openZstdRange returns a reader over n bytes at off in a seekable zstd
+// file, fetching and decompressing only the frames that hold them.
+func (x *FileExtractor) openZstdRange(off, n int64) (io.ReadCloser, error) {
+	frames := x.zstd.Locate(off, n)
+	if len(frames) == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	first, last := frames[0], frames[len(frames)-1]
+	body, err := x.getRange(first.CompressedOffset, last.CompressedOffset+last.CompressedSize-1)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zstdseek.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	rc := &limitedReadCloser{zr, multiCloser{zr, body}}
+	if _, err := io.CopyN(ioutil.Discard, rc, off-first.Offset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(rc, n), rc}, nil
+}
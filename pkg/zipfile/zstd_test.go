@@ -0,0 +1,110 @@
+package zipfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// seekableZstd returns a seekable zstd file with a frame for each of the
+// given strings.
+func seekableZstd(t *testing.T, frames ...string) []byte {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b, table bytes.Buffer
+	for _, f := range frames {
+		c := enc.EncodeAll([]byte(f), nil)
+		b.Write(c)
+		binary.Write(&table, binary.LittleEndian, uint32(len(c)))
+		binary.Write(&table, binary.LittleEndian, uint32(len(f)))
+	}
+	binary.Write(&b, binary.LittleEndian, uint32(0x184D2A5E))
+	binary.Write(&b, binary.LittleEndian, uint32(table.Len()+9))
+	b.Write(table.Bytes())
+	binary.Write(&b, binary.LittleEndian, uint32(len(frames)))
+	b.WriteByte(0)
+	binary.Write(&b, binary.LittleEndian, uint32(0x8F92EAB1))
+	return b.Bytes()
+}
+
+func TestSeekableZstd(t *testing.T) {
+	frames := []string{strings.Repeat("a", 1000), strings.Repeat("b", 1000), strings.Repeat("c", 1000)}
+	data := seekableZstd(t, frames...)
+	all := strings.Join(frames, "")
+
+	a := openBytes(t, data)
+	files := a.Files()
+	if len(files) != 1 || files[0].Name != "data" || files[0].UncompressedSize64 != uint64(len(all)) {
+		t.Fatalf("Files() = %+v; want a single entry named data of %d bytes", files, len(all))
+	}
+	rc, err := a.Open("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != all {
+		t.Errorf("Open read %d bytes, %v; want %d", len(b), err, len(all))
+	}
+
+	tests := []ByteRange{
+		{Start: 10, End: 19},
+		{Start: 990, End: 1009}, // across a frame boundary
+		{Start: 2500, End: -1},
+		{Start: 0, End: 2999},
+	}
+	for _, r := range tests {
+		rc, err := a.OpenRange("data", r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		end := r.End + 1
+		if r.End < 0 {
+			end = int64(len(all))
+		}
+		if want := all[r.Start:end]; err != nil || string(b) != want {
+			t.Errorf("OpenRange(%+v) = %q, %v; want %q", r, b, err, want)
+		}
+	}
+
+	// A range in the last frame fetches only that frame.
+	src := &countingSource{b: data}
+	a, err = Open(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := src.read
+	rc, err = a.OpenRange("data", ByteRange{Start: 2500, End: 2509})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(rc)
+	rc.Close()
+	last := int64(a.Files()[0].CompressedSize64) / 3 * 2
+	if n := src.read - before; n == 0 || n > last {
+		t.Errorf("read %d bytes for a range in the last frame; want at most %d", n, last)
+	}
+}
+
+func TestSeekableZstdFormat(t *testing.T) {
+	data := seekableZstd(t, "one", "two")
+	// A table whose sizes don't add up to the object's isn't taken as a
+	// seekable zstd file.
+	if _, err := Open(context.Background(), ReaderAt(bytes.NewReader(data[1:]), int64(len(data)-1))); err == nil {
+		t.Error("Open of a file with bytes missing before its seek table succeeded")
+	}
+	bad := append([]byte(nil), data...)
+	bad[len(bad)-1] = 0 // the seekable magic
+	if _, err := Open(context.Background(), ReaderAt(bytes.NewReader(bad), int64(len(bad)))); err == nil {
+		t.Error("Open of a file with a bad seekable magic succeeded")
+	}
+}
@@ -0,0 +1,124 @@
+// Package zstdseek reads the seek table of the seekable Zstandard format.
+//
+// A seekable zstd file is a series of independently compressed zstd frames
+// followed by a skippable frame holding a seek table: the compressed and
+// decompressed size of every frame. With the table, the frames covering any
+// range of the decompressed data can be located and decompressed on their
+// own. See
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+package zstdseek
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// FooterSize is the size of the seek table footer at the end of a file
+	FooterSize = 9
+
+	skippableMagic  = 0x184D2A5E
+	seekableMagic   = 0x8F92EAB1
+	skippableHeader = 8 // magic number and frame size
+	checksumFlag    = 1 << 7
+	reservedBits    = 0x7c
+)
+
+// ErrFormat indicates data that is not in the seekable zstd format
+var ErrFormat = errors.New("zstdseek: not a seekable zstd file")
+
+// Frame is one independently decompressible zstd frame.
+type Frame struct {
+	CompressedOffset int64
+	CompressedSize   int64
+	Offset           int64 // in the decompressed data
+	Size             int64 // decompressed
+	Checksum         uint32
+}
+
+// SeekTable lists the frames of a seekable zstd file.
+type SeekTable struct {
+	Frames    []Frame
+	Checksums bool  // whether each frame's checksum is set
+	TableSize int64 // size of the skippable frame holding the table
+
+	CompressedSize int64 // of all frames, excluding the seek table
+	Size           int64 // of the decompressed data
+}
+
+// ParseFooter returns the size of the skippable frame holding the seek
+// table, given the last FooterSize bytes of a file.
+func ParseFooter(b []byte) (int64, error) {
+	if len(b) != FooterSize || binary.LittleEndian.Uint32(b[5:]) != seekableMagic || b[4]&reservedBits != 0 {
+		return 0, ErrFormat
+	}
+	entrySize := int64(8)
+	if b[4]&checksumFlag != 0 {
+		entrySize = 12
+	}
+	return skippableHeader + int64(binary.LittleEndian.Uint32(b))*entrySize + FooterSize, nil
+}
+
+// ParseSeekTable parses the skippable frame holding a seek table, as sized
+// by ParseFooter.
+func ParseSeekTable(b []byte) (*SeekTable, error) {
+	if len(b) < skippableHeader+FooterSize {
+		return nil, ErrFormat
+	}
+	tableSize, err := ParseFooter(b[len(b)-FooterSize:])
+	if err != nil || tableSize != int64(len(b)) ||
+		binary.LittleEndian.Uint32(b) != skippableMagic ||
+		int64(binary.LittleEndian.Uint32(b[4:])) != tableSize-skippableHeader {
+		return nil, ErrFormat
+	}
+	t := &SeekTable{
+		Checksums: b[len(b)-FooterSize+4]&checksumFlag != 0,
+		TableSize: tableSize,
+	}
+	entrySize := 8
+	if t.Checksums {
+		entrySize = 12
+	}
+	entries := b[skippableHeader : len(b)-FooterSize]
+	t.Frames = make([]Frame, 0, len(entries)/entrySize)
+	for ; len(entries) > 0; entries = entries[entrySize:] {
+		f := Frame{
+			CompressedOffset: t.CompressedSize,
+			CompressedSize:   int64(binary.LittleEndian.Uint32(entries)),
+			Offset:           t.Size,
+			Size:             int64(binary.LittleEndian.Uint32(entries[4:])),
+		}
+		if t.Checksums {
+			f.Checksum = binary.LittleEndian.Uint32(entries[8:])
+		}
+		t.Frames = append(t.Frames, f)
+		t.CompressedSize += f.CompressedSize
+		t.Size += f.Size
+	}
+	return t, nil
+}
+
+// Locate returns the frames holding the n decompressed bytes at off.
+func (t *SeekTable) Locate(off, n int64) []Frame {
+	frames := t.Frames
+	i := sort.Search(len(frames), func(i int) bool { return frames[i].Offset+frames[i].Size > off })
+	j := sort.Search(len(frames), func(j int) bool { return frames[j].Offset >= off+n })
+	if i >= j {
+		return nil
+	}
+	return frames[i:j]
+}
+
+// NewReader returns a reader that decompresses the consecutive frames read
+// from r. Frame checksums, when present, are verified by the decoder.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
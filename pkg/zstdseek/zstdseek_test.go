@@ -0,0 +1,175 @@
+package zstdseek
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testFrames are the decompressed contents of the frames of the file made
+// by seekable.
+var testFrames = []string{"first frame\n", "the second, longer frame\n", "third\n"}
+
+// seekable returns a seekable zstd file of testFrames, with frame checksums
+// in the seek table if checksums is set, and the frames alone.
+func seekable(t *testing.T, checksums bool) (file, frames []byte) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var table bytes.Buffer
+	for _, f := range testFrames {
+		c := enc.EncodeAll([]byte(f), nil)
+		frames = append(frames, c...)
+		binary.Write(&table, binary.LittleEndian, uint32(len(c)))
+		binary.Write(&table, binary.LittleEndian, uint32(len(f)))
+		if checksums {
+			binary.Write(&table, binary.LittleEndian, uint32(0xc0ffee))
+		}
+	}
+	descriptor := byte(0)
+	if checksums {
+		descriptor = checksumFlag
+	}
+	var b bytes.Buffer
+	b.Write(frames)
+	binary.Write(&b, binary.LittleEndian, uint32(skippableMagic))
+	binary.Write(&b, binary.LittleEndian, uint32(table.Len()+FooterSize))
+	b.Write(table.Bytes())
+	binary.Write(&b, binary.LittleEndian, uint32(len(testFrames)))
+	b.WriteByte(descriptor)
+	binary.Write(&b, binary.LittleEndian, uint32(seekableMagic))
+	return b.Bytes(), frames
+}
+
+// readTable parses the seek table at the end of file.
+func readTable(file []byte) (*SeekTable, error) {
+	tableSize, err := ParseFooter(file[len(file)-FooterSize:])
+	if err != nil {
+		return nil, err
+	}
+	if tableSize > int64(len(file)) {
+		return nil, ErrFormat
+	}
+	return ParseSeekTable(file[int64(len(file))-tableSize:])
+}
+
+func TestParseSeekTable(t *testing.T) {
+	for _, checksums := range []bool{false, true} {
+		file, frames := seekable(t, checksums)
+		table, err := readTable(file)
+		if err != nil {
+			t.Fatalf("checksums %v: %v", checksums, err)
+		}
+		if table.Checksums != checksums || len(table.Frames) != len(testFrames) {
+			t.Fatalf("checksums %v: got %d frames, checksums %v", checksums, len(table.Frames), table.Checksums)
+		}
+		if table.CompressedSize != int64(len(frames)) || table.CompressedSize+table.TableSize != int64(len(file)) {
+			t.Errorf("checksums %v: compressed size %d and table size %d; want %d in all", checksums, table.CompressedSize, table.TableSize, len(file))
+		}
+		var off, coff int64
+		for i, f := range table.Frames {
+			if f.Offset != off || f.Size != int64(len(testFrames[i])) || f.CompressedOffset != coff {
+				t.Errorf("checksums %v: frame %d = %+v; want offset %d, size %d, compressed offset %d", checksums, i, f, off, len(testFrames[i]), coff)
+			}
+			if checksums && f.Checksum != 0xc0ffee {
+				t.Errorf("frame %d checksum = %#x; want 0xc0ffee", i, f.Checksum)
+			}
+			off += f.Size
+			coff += f.CompressedSize
+		}
+		if table.Size != off {
+			t.Errorf("checksums %v: size %d; want %d", checksums, table.Size, off)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	file, frames := seekable(t, false)
+	table, err := readTable(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := int64(len(testFrames[0])), int64(len(testFrames[1]))
+	tests := []struct {
+		name      string
+		off, n    int64
+		from, end int // frames wanted
+	}{
+		{"all", 0, table.Size, 0, 3},
+		{"within the first", 1, 2, 0, 1},
+		{"across a boundary", first - 1, 2, 0, 2},
+		{"exactly the second", first, second, 1, 2},
+		{"the last byte", table.Size - 1, 1, 2, 3},
+		{"nothing", first, 0, 0, 0},
+		{"past the end", table.Size, 10, 0, 0},
+	}
+	for _, tt := range tests {
+		got := table.Locate(tt.off, tt.n)
+		want := table.Frames[tt.from:tt.end]
+		if len(got) != len(want) || len(got) > 0 && got[0] != want[0] {
+			t.Errorf("%s: Locate(%d, %d) = %+v; want %+v", tt.name, tt.off, tt.n, got, want)
+			continue
+		}
+		if len(got) == 0 {
+			continue
+		}
+		// The located frames decompress on their own.
+		start, end := got[0].CompressedOffset, got[len(got)-1].CompressedOffset+got[len(got)-1].CompressedSize
+		r, err := NewReader(bytes.NewReader(frames[start:end]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		var wantData string
+		for _, f := range testFrames[tt.from:tt.end] {
+			wantData += f
+		}
+		if err != nil || string(b) != wantData {
+			t.Errorf("%s: frames decompress to %q, %v; want %q", tt.name, b, err, wantData)
+		}
+	}
+}
+
+func TestParseSeekTableFormat(t *testing.T) {
+	file, _ := seekable(t, true)
+	corrupt := func(off int, v byte) []byte {
+		b := append([]byte(nil), file...)
+		b[len(b)+off] = v
+		return b
+	}
+	tableSize, err := ParseFooter(file[len(file)-FooterSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableStart := len(file) - int(tableSize)
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"bad seekable magic", corrupt(-1, 0)},
+		{"reserved bits", corrupt(-5, checksumFlag|0x04)},
+		{"checksum flag cleared", corrupt(-5, 0)},
+		{"frame count too large", corrupt(-FooterSize, byte(len(testFrames)+1))},
+		{"bad skippable magic", corrupt(tableStart-len(file), 0)},
+		{"bad frame size", corrupt(tableStart-len(file)+4, 0)},
+	}
+	for _, tt := range tests {
+		if _, err := readTable(tt.file); err != ErrFormat {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, ErrFormat)
+		}
+	}
+	if _, err := ParseFooter(file[len(file)-FooterSize+1:]); err != ErrFormat {
+		t.Errorf("short footer: err = %v; want %v", err, ErrFormat)
+	}
+	if _, err := ParseSeekTable(file[tableStart+1:]); err != ErrFormat {
+		t.Errorf("truncated table: err = %v; want %v", err, ErrFormat)
+	}
+	if _, err := ParseSeekTable(file[len(file)-FooterSize-1:]); err != ErrFormat {
+		t.Errorf("table shorter than a header and footer: err = %v; want %v", err, ErrFormat)
+	}
+}